@@ -0,0 +1,192 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"nstorm.com/main-backend/auth"
+	"nstorm.com/main-backend/middleware"
+)
+
+type Action string
+
+const (
+	Create   Action = "CREATE"
+	Update   Action = "UPDATE"
+	Delete   Action = "DELETE"
+	Assign   Action = "ASSIGN"
+	Unassign Action = "UNASSIGN"
+)
+
+type Entity string
+
+const (
+	Employee     Entity = "employee"
+	Project      Entity = "project"
+	Task         Entity = "task"
+	Membership   Entity = "membership"
+	Dependency   Entity = "task_dependency"
+	Comment      Entity = "task_comment"
+	Label        Entity = "label"
+	TaskLabel    Entity = "task_label"
+	ProjectLabel Entity = "project_label"
+	Attachment   Entity = "attachment"
+	TimeEntry    Entity = "time_entry"
+	Sprint       Entity = "sprint"
+	Milestone    Entity = "milestone"
+)
+
+// snapshotQueries select the audited state of an entity as JSON. Credentials
+// never end up in the audit log.
+var snapshotQueries = map[Entity]string{
+	Employee:     `SELECT to_jsonb(e) - 'password_hash' FROM employees e WHERE id = $1`,
+	Project:      `SELECT to_jsonb(p) FROM projects p WHERE id = $1`,
+	Task:         `SELECT to_jsonb(t) FROM tasks t WHERE id = $1`,
+	Membership:   `SELECT to_jsonb(ep) FROM employee_projects ep WHERE employee_id = $1 AND project_id = $2`,
+	Dependency:   `SELECT to_jsonb(d) FROM task_dependencies d WHERE task_id = $1 AND depends_on_id = $2`,
+	Comment:      `SELECT to_jsonb(c) || jsonb_build_object('mentions', ` + commentMentions + `) FROM task_comments c WHERE id = $1`,
+	Label:        `SELECT to_jsonb(l) FROM labels l WHERE id = $1`,
+	TaskLabel:    `SELECT to_jsonb(tl) FROM task_labels tl WHERE task_id = $1 AND label_id = $2`,
+	ProjectLabel: `SELECT to_jsonb(pl) FROM project_labels pl WHERE project_id = $1 AND label_id = $2`,
+	Attachment:   `SELECT to_jsonb(a) - 'storage_key' FROM attachments a WHERE id = $1`,
+	TimeEntry:    `SELECT to_jsonb(te) FROM time_entries te WHERE id = $1`,
+	Sprint:       `SELECT to_jsonb(s) FROM sprints s WHERE id = $1`,
+	Milestone:    `SELECT to_jsonb(m) FROM milestones m WHERE id = $1`,
+}
+
+// commentMentions lists the employees mentioned in comment c, so edits that
+// change who is mentioned show up in the diff.
+const commentMentions = `COALESCE((SELECT jsonb_agg(m.employee_id ORDER BY m.employee_id) FROM task_comment_mentions m WHERE m.comment_id = c.id), '[]')`
+
+// DB is the subset of pgxpool.Pool and pgx.Tx the recorder needs, so changes made
+// inside a transaction can be audited in the same transaction.
+type DB interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// Recorder writes audit events for mutations made by the handlers.
+type Recorder struct {
+	db DB
+}
+
+func NewRecorder(db DB) *Recorder {
+	return &Recorder{db: db}
+}
+
+// In returns a recorder that reads and writes through tx.
+func (r *Recorder) In(tx DB) *Recorder {
+	return &Recorder{db: tx}
+}
+
+// Change tracks one entity across a mutation. Create it with Begin before
+// changing the entity and call Record afterwards.
+type Change struct {
+	recorder *Recorder
+	entity   Entity
+	key      []any
+	before   map[string]any
+}
+
+// Begin snapshots the entity identified by key before it is changed. For a
+// create there is nothing to snapshot yet; use Created instead.
+func (r *Recorder) Begin(ctx context.Context, entity Entity, key ...any) *Change {
+	return &Change{recorder: r, entity: entity, key: key, before: r.snapshot(ctx, entity, key)}
+}
+
+// Created starts tracking an entity that was just inserted.
+func (r *Recorder) Created(entity Entity, key ...any) *Change {
+	return &Change{recorder: r, entity: entity, key: key}
+}
+
+// Record snapshots the entity again and stores the event unless nothing
+// changed. Failures are reported on stderr rather than to the client, since
+// the mutation itself has already been applied.
+func (c *Change) Record(ctx context.Context, action Action) {
+	// The mutation has happened; record it even if the client went away
+	ctx = context.WithoutCancel(ctx)
+
+	after := c.recorder.snapshot(ctx, c.entity, c.key)
+	diff := Diff(c.before, after)
+	if len(diff) == 0 {
+		return
+	}
+
+	var actorID *int
+	principal := auth.FromContext(ctx)
+	if principal != nil && principal.EmployeeID != 0 {
+		actorID = &principal.EmployeeID
+	}
+
+	query := `
+        INSERT INTO audit_events (actor_employee_id, actor, action, entity_type, entity_id, before, after, diff, request_id)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, ''))`
+
+	_, err := c.recorder.db.Exec(ctx, query,
+		actorID,
+		principal.String(),
+		action,
+		c.entity,
+		entityID(c.key),
+		nullableJSON(c.before),
+		nullableJSON(after),
+		diff,
+		middleware.RequestIDFromContext(ctx),
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, "audit: failed to record event", "action", action, "entity", c.entity, "entity_id", entityID(c.key), "error", err)
+	}
+}
+
+func (r *Recorder) snapshot(ctx context.Context, entity Entity, key []any) map[string]any {
+	var state map[string]any
+	err := r.db.QueryRow(ctx, snapshotQueries[entity], key...).Scan(&state)
+	if err != nil && err != pgx.ErrNoRows {
+		slog.ErrorContext(ctx, "audit: failed to snapshot entity", "entity", entity, "entity_id", entityID(key), "error", err)
+	}
+	return state
+}
+
+// FieldChange is one entry of the diff stored with an event.
+type FieldChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+// Diff returns the fields whose values differ between before and after.
+func Diff(before, after map[string]any) map[string]FieldChange {
+	diff := make(map[string]FieldChange)
+	for field, from := range before {
+		if to, ok := after[field]; !ok || !reflect.DeepEqual(from, to) {
+			diff[field] = FieldChange{From: from, To: after[field]}
+		}
+	}
+	for field, to := range after {
+		if _, ok := before[field]; !ok {
+			diff[field] = FieldChange{To: to}
+		}
+	}
+	return diff
+}
+
+func entityID(key []any) string {
+	parts := make([]string, len(key))
+	for i, k := range key {
+		parts[i] = fmt.Sprint(k)
+	}
+	return strings.Join(parts, ":")
+}
+
+func nullableJSON(state map[string]any) any {
+	if state == nil {
+		return nil
+	}
+	b, _ := json.Marshal(state)
+	return b
+}
@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// APIKeyPrefix marks API keys so they can be told apart from JWTs when both
+// arrive as bearer tokens.
+const APIKeyPrefix = "nsk_"
+
+// NewAPIKey returns a fresh API key together with the hash and display
+// prefix to store. The key itself is only ever shown once.
+func NewAPIKey() (key, hash, prefix string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", "", err
+	}
+	key = APIKeyPrefix + base64.RawURLEncoding.EncodeToString(buf)
+	return key, HashAPIKey(key), key[:len(APIKeyPrefix)+6], nil
+}
+
+// HashAPIKey returns the stored form of an API key. Keys carry 256 bits of
+// entropy so a plain SHA-256 is sufficient; no per-key salt is needed.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrUnauthenticated = errors.New("authentication required")
+
+// Authenticator resolves the credentials on a request into a Principal. It
+// accepts a JWT or an API key as a bearer token, or an API key in X-API-Key.
+type Authenticator struct {
+	db     *pgxpool.Pool
+	tokens *TokenIssuer
+}
+
+func NewAuthenticator(db *pgxpool.Pool, tokens *TokenIssuer) *Authenticator {
+	return &Authenticator{db: db, tokens: tokens}
+}
+
+func (a *Authenticator) Authenticate(ctx context.Context, r *http.Request) (*Principal, error) {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return a.authenticateAPIKey(ctx, key)
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil, ErrUnauthenticated
+	}
+	if IsAPIKey(token) {
+		return a.authenticateAPIKey(ctx, token)
+	}
+	return a.authenticateToken(ctx, token)
+}
+
+// authenticateToken verifies a JWT and reloads the employee so that deleted
+// employees and role changes take effect before the token expires.
+func (a *Authenticator) authenticateToken(ctx context.Context, token string) (*Principal, error) {
+	claims, err := a.tokens.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	employeeID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	p := &Principal{EmployeeID: employeeID}
+	err = a.db.QueryRow(ctx, `SELECT name, role FROM employees WHERE id = $1`, employeeID).Scan(&p.Name, &p.Role)
+	if err == pgx.ErrNoRows {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (a *Authenticator) authenticateAPIKey(ctx context.Context, key string) (*Principal, error) {
+	query := `
+        SELECT k.id, k.name, COALESCE(e.id, 0), COALESCE(e.name, ''), COALESCE(e.role, '')
+        FROM api_keys k
+        LEFT JOIN employees e ON e.id = k.employee_id
+        WHERE k.key_hash = $1 AND k.revoked_at IS NULL
+          AND (k.expires_at IS NULL OR k.expires_at > CURRENT_TIMESTAMP)`
+
+	var p Principal
+	var keyName, employeeName string
+	err := a.db.QueryRow(ctx, query, HashAPIKey(key)).Scan(&p.APIKeyID, &keyName, &p.EmployeeID, &employeeName, &p.Role)
+	if err == pgx.ErrNoRows {
+		return nil, ErrUnauthenticated
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := a.db.Exec(ctx, `UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP WHERE id = $1`, p.APIKeyID); err != nil {
+		return nil, err
+	}
+
+	if p.EmployeeID == 0 {
+		p.Service = true
+		p.Name = keyName
+	} else {
+		p.Name = employeeName
+	}
+	return &p, nil
+}
@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"nstorm.com/main-backend/models"
+)
+
+var ErrForbidden = errors.New("forbidden")
+
+// Action is something a principal may or may not be allowed to do.
+type Action string
+
+const (
+	ManageEmployees       Action = "employees:manage"
+	ManageServiceAccounts Action = "service-accounts:manage"
+	CreateProject         Action = "projects:create"
+	UpdateProject         Action = "projects:update"
+	DeleteProject         Action = "projects:delete"
+	ManageMembers         Action = "projects:members"
+	ManageBudget          Action = "projects:budget"
+	GenerateTasks         Action = "projects:generate"
+	ManageTasks           Action = "tasks:manage"
+	ViewAudit             Action = "audit:view"
+)
+
+// leadActions are the project-scoped actions a project's lead may perform on
+// their own project regardless of their employee role. Members holding the
+// LEAD membership role count as leads for the duration of their membership.
+var leadActions = map[Action]bool{
+	UpdateProject: true,
+	ManageMembers: true,
+	GenerateTasks: true,
+	ManageTasks:   true,
+}
+
+// Policy decides what an authenticated principal may do. Service accounts
+// and project managers may do everything; project leads get the lead actions
+// on their own projects; developers may only change the status of tasks
+// assigned to them.
+type Policy struct {
+	db *pgxpool.Pool
+}
+
+func NewPolicy(db *pgxpool.Pool) *Policy {
+	return &Policy{db: db}
+}
+
+// Authorize returns ErrForbidden when principal may not perform action.
+// projectID scopes the action to a project; pass 0 for global actions.
+func (p *Policy) Authorize(ctx context.Context, principal *Principal, action Action, projectID int) error {
+	if principal == nil {
+		return ErrForbidden
+	}
+	if principal.Service || principal.Role == models.RoleProjectManager {
+		return nil
+	}
+	if projectID != 0 && leadActions[action] {
+		lead, err := p.isProjectLead(ctx, principal.EmployeeID, projectID)
+		if err != nil {
+			return err
+		}
+		if lead {
+			return nil
+		}
+	}
+	return ErrForbidden
+}
+
+// AuthorizeTaskUpdate checks an update of current to updated. Principals
+// allowed to manage tasks on the project may change anything; the assignee
+// may only change the status and the actual effort.
+func (p *Policy) AuthorizeTaskUpdate(ctx context.Context, principal *Principal, current, updated models.Task) error {
+	err := p.Authorize(ctx, principal, ManageTasks, current.ProjectID)
+	if err == nil && updated.ProjectID != current.ProjectID {
+		// Moving a task requires rights on the destination project as well
+		err = p.Authorize(ctx, principal, ManageTasks, updated.ProjectID)
+	}
+	if err != ErrForbidden {
+		return err
+	}
+
+	if principal.EmployeeID == 0 || current.AssignedTo != principal.EmployeeID {
+		return ErrForbidden
+	}
+	if updated.ProjectID != current.ProjectID ||
+		updated.AssignedTo != current.AssignedTo ||
+		!sameValue(updated.ParentTaskID, current.ParentTaskID) ||
+		!sameValue(updated.SprintID, current.SprintID) ||
+		!sameValue(updated.MilestoneID, current.MilestoneID) ||
+		updated.Title != current.Title ||
+		updated.Description != current.Description ||
+		updated.Priority != current.Priority ||
+		!sameDate(updated.StartDate, current.StartDate) ||
+		!sameDate(updated.DueDate, current.DueDate) ||
+		!sameValue(updated.StoryPoints, current.StoryPoints) ||
+		!sameValue(updated.EstimateHours, current.EstimateHours) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+func sameValue[T comparable](a, b *T) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func sameDate(a, b *models.Date) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(b.Time)
+}
+
+func (p *Policy) isProjectLead(ctx context.Context, employeeID, projectID int) (bool, error) {
+	var lead bool
+	query := `
+        SELECT p.lead_id = $2 OR EXISTS (
+            SELECT 1 FROM employee_projects ep
+            WHERE ep.project_id = p.id AND ep.employee_id = $2 AND ep.role = 'LEAD'
+              AND (ep.start_date IS NULL OR ep.start_date <= CURRENT_DATE)
+              AND (ep.end_date IS NULL OR ep.end_date >= CURRENT_DATE))
+        FROM projects p
+        WHERE p.id = $1`
+
+	err := p.db.QueryRow(ctx, query, projectID, employeeID).Scan(&lead)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	return lead, err
+}
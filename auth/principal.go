@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"context"
+	"strconv"
+
+	"nstorm.com/main-backend/models"
+)
+
+// Principal is the authenticated caller of a request. Service accounts
+// authenticate with an API key that is not tied to an employee; for them
+// EmployeeID is zero and Service is set.
+type Principal struct {
+	EmployeeID int                 `json:"employee_id,omitempty"`
+	Name       string              `json:"name"`
+	Role       models.EmployeeRole `json:"role,omitempty"`
+	APIKeyID   int                 `json:"api_key_id,omitempty"`
+	Service    bool                `json:"service"`
+}
+
+type contextKey struct{}
+
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the principal attached by the authentication
+// middleware, or nil for unauthenticated requests.
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(contextKey{}).(*Principal)
+	return p
+}
+
+// String identifies the principal in logs and idempotency keys. Service
+// accounts are identified by their API key, since key names are not unique.
+func (p *Principal) String() string {
+	if p == nil {
+		return "anonymous"
+	}
+	if p.Service {
+		return "api-key:" + strconv.Itoa(p.APIKeyID)
+	}
+	return "employee:" + strconv.Itoa(p.EmployeeID)
+}
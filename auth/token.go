@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"nstorm.com/main-backend/models"
+)
+
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+const issuer = "main-backend"
+
+// Claims is the payload of the JWTs issued at login.
+type Claims struct {
+	Subject   string              `json:"sub"`
+	Name      string              `json:"name"`
+	Role      models.EmployeeRole `json:"role"`
+	Issuer    string              `json:"iss"`
+	IssuedAt  int64               `json:"iat"`
+	ExpiresAt int64               `json:"exp"`
+}
+
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// TokenIssuer signs and verifies HS256 JWTs.
+type TokenIssuer struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+func NewTokenIssuer(secret []byte, ttl time.Duration) *TokenIssuer {
+	return &TokenIssuer{secret: secret, ttl: ttl}
+}
+
+func (t *TokenIssuer) Issue(employee models.Employee) (string, time.Time, error) {
+	now := time.Now()
+	expires := now.Add(t.ttl)
+	payload, err := json.Marshal(Claims{
+		Subject:   strconv.Itoa(employee.ID),
+		Name:      employee.Name,
+		Role:      employee.Role,
+		Issuer:    issuer,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expires.Unix(),
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	signingInput := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signingInput + "." + t.sign(signingInput), expires, nil
+}
+
+// Verify checks the signature and expiry of token and returns its claims.
+func (t *TokenIssuer) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != jwtHeader {
+		return nil, ErrInvalidToken
+	}
+	expected := t.sign(parts[0] + "." + parts[1])
+	if !hmac.Equal([]byte(expected), []byte(parts[2])) {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if claims.Issuer != issuer || time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrInvalidToken
+	}
+	return &claims, nil
+}
+
+func (t *TokenIssuer) sign(signingInput string) string {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte(signingInput))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -166,13 +166,32 @@ class AgentMessage(BaseModel):
     speaker: str
     message: str
 
+class Usage(BaseModel):
+    prompt_tokens: int = 0
+    completion_tokens: int = 0
+    total_tokens: int = 0
+    cost_usd: float = 0.0
+
 class ChatResponse(BaseModel):
     status: str
     message: str
     tasks: List[TaskAssignment]
+    usage: Optional[Usage] = None
     project_manager_message: Optional[str] = None  # Add project_manager_message field
     task_assigner_message: Optional[str] = None  # Add task_assigner_message field
 
+def collect_usage(team: ProjectTeam) -> Optional[Usage]:
+    summary = autogen.gather_usage_summary(list(team.agents.values()) + [team.manager])
+    totals = summary.get("usage_including_cached_inference") or {}
+    usage = Usage(cost_usd=totals.get("total_cost", 0.0))
+    for model, stats in totals.items():
+        if model == "total_cost":
+            continue
+        usage.prompt_tokens += stats.get("prompt_tokens", 0)
+        usage.completion_tokens += stats.get("completion_tokens", 0)
+        usage.total_tokens += stats.get("total_tokens", 0)
+    return usage if usage.total_tokens else None
+
 def parse_assignments(text: str) -> List[TaskAssignment]:
     lines = [line.strip() for line in text.split('\n') if line.strip()]
     
@@ -191,6 +210,10 @@ def parse_assignments(text: str) -> List[TaskAssignment]:
     
     return assignments
 
+@app.get("/health")
+async def health() -> dict:
+    return {"status": "ok"}
+
 @app.post("/chat", response_model=ChatResponse)
 async def chat_endpoint(request: ChatRequest) -> ChatResponse:
     team = ProjectTeam()
@@ -236,6 +259,7 @@ async def chat_endpoint(request: ChatRequest) -> ChatResponse:
             status="success",
             message=detailed_message.strip(),
             tasks=tasks,
+            usage=collect_usage(team),
             project_manager_message=project_manager_message,  # Include ProjectManager message
             task_assigner_message=task_assigner_message  # Include TaskAssigner message
         )
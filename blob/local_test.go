@@ -0,0 +1,20 @@
+package blob
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewLocalStore(filepath.Join(dir, "blobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testRoundTrip(t, store, "tasks/1")
+
+	if _, err := os.Stat(filepath.Join(dir, "escape.txt")); !os.IsNotExist(err) {
+		t.Errorf("a file was written outside the store: %v", err)
+	}
+}
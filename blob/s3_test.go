@@ -0,0 +1,39 @@
+package blob
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestS3Store runs against the bucket configured like the server, e.g. a
+// local MinIO with S3_ENDPOINT=localhost:9000 and S3_USE_SSL=false.
+func TestS3Store(t *testing.T) {
+	endpoint := os.Getenv("S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("S3_ENDPOINT is not set")
+	}
+	useSSL := true
+	if value := os.Getenv("S3_USE_SSL"); value != "" {
+		var err error
+		if useSSL, err = strconv.ParseBool(value); err != nil {
+			t.Fatalf("S3_USE_SSL: %v", err)
+		}
+	}
+
+	store, err := NewS3Store(context.Background(), S3Options{
+		Endpoint:  endpoint,
+		Bucket:    os.Getenv("S3_BUCKET"),
+		Region:    os.Getenv("S3_REGION"),
+		AccessKey: os.Getenv("S3_ACCESS_KEY"),
+		SecretKey: os.Getenv("S3_SECRET_KEY"),
+		UseSSL:    useSSL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testRoundTrip(t, store, fmt.Sprintf("test/%d", time.Now().UnixNano()))
+}
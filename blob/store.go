@@ -0,0 +1,22 @@
+// Package blob stores the contents of uploaded files. Metadata such as names,
+// sizes and checksums lives in Postgres; a Store only maps keys to bytes.
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrNotFound is returned for keys that do not exist.
+var ErrNotFound = errors.New("blob not found")
+
+// Store holds blobs under keys such as "tasks/12/<random>". Keys are chosen
+// by the caller and contain only letters, digits, '-', '_', '.' and '/'.
+type Store interface {
+	// Put writes size bytes from r under key, replacing any existing blob.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes key. Deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+}
@@ -0,0 +1,52 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// testRoundTrip puts, reads and deletes a blob below prefix, and checks how
+// store handles missing and invalid keys.
+func testRoundTrip(t *testing.T, store Store, prefix string) {
+	ctx := context.Background()
+	key := prefix + "/report.txt"
+	content := "quarterly numbers"
+
+	if err := store.Put(ctx, key, strings.NewReader(content), int64(len(content)), "text/plain"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	r, err := store.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(got) != content {
+		t.Errorf("Get returned %q, want %q", got, content)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete returned %v, want ErrNotFound", err)
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Errorf("Delete of a missing key returned %v", err)
+	}
+
+	if _, err := store.Get(ctx, prefix+"/missing.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a missing key returned %v, want ErrNotFound", err)
+	}
+
+	if err := store.Put(ctx, "../escape.txt", strings.NewReader(content), int64(len(content)), "text/plain"); err == nil {
+		t.Error("Put accepted a key outside the store")
+	}
+}
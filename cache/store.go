@@ -0,0 +1,150 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrFingerprintMismatch is returned when a key is reused for a request whose
+// fingerprint differs from the one that first claimed the key.
+var ErrFingerprintMismatch = errors.New("key reused with a different request")
+
+type Outcome string
+
+const (
+	Miss      Outcome = "miss"
+	Coalesced Outcome = "coalesced"
+	Replayed  Outcome = "replayed"
+)
+
+// Response is a captured HTTP response.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+func (r *Response) Successful() bool {
+	return r.Status >= 200 && r.Status < 300
+}
+
+// WriteTo copies the captured response onto w.
+func (r *Response) WriteTo(w http.ResponseWriter) {
+	for key, values := range r.Header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(r.Status)
+	w.Write(r.Body)
+}
+
+// Recorder is an http.ResponseWriter that captures the response in memory.
+type Recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{header: make(http.Header)}
+}
+
+func (r *Recorder) Header() http.Header {
+	return r.header
+}
+
+func (r *Recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *Recorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(b)
+}
+
+func (r *Recorder) Response() *Response {
+	status := r.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return &Response{Status: status, Header: r.header.Clone(), Body: r.body.Bytes()}
+}
+
+type entry struct {
+	fingerprint string
+	done        chan struct{}
+	response    *Response
+	expires     time.Time
+}
+
+// Store coalesces concurrent requests that share a key and keeps successful
+// responses around for ttl so they can be replayed. Failed responses are
+// handed to requests that were waiting on them and then forgotten, which lets
+// the caller retry.
+type Store struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]*entry
+}
+
+func NewStore(ttl time.Duration) *Store {
+	return &Store{ttl: ttl, entries: make(map[string]*entry)}
+}
+
+// Do returns the response for key, running fn to produce it only if no other
+// request with the same key is in flight or was recently answered.
+func (s *Store) Do(key, fingerprint string, fn func(w http.ResponseWriter)) (*Response, Outcome, error) {
+	s.mu.Lock()
+	s.pruneLocked(time.Now())
+	if e, ok := s.entries[key]; ok {
+		s.mu.Unlock()
+		if e.fingerprint != fingerprint {
+			return nil, "", ErrFingerprintMismatch
+		}
+		select {
+		case <-e.done:
+			return e.response, Replayed, nil
+		default:
+		}
+		<-e.done
+		return e.response, Coalesced, nil
+	}
+
+	e := &entry{fingerprint: fingerprint, done: make(chan struct{})}
+	s.entries[key] = e
+	s.mu.Unlock()
+
+	recorder := NewRecorder()
+	defer func() {
+		if e.response == nil {
+			// fn panicked; release waiters with a generic failure.
+			e.response = &Response{Status: http.StatusInternalServerError, Header: make(http.Header)}
+		}
+		s.mu.Lock()
+		if e.response.Successful() && s.ttl > 0 {
+			e.expires = time.Now().Add(s.ttl)
+		} else {
+			delete(s.entries, key)
+		}
+		s.mu.Unlock()
+		close(e.done)
+	}()
+
+	fn(recorder)
+	e.response = recorder.Response()
+	return e.response, Miss, nil
+}
+
+func (s *Store) pruneLocked(now time.Time) {
+	for key, e := range s.entries {
+		if !e.expires.IsZero() && now.After(e.expires) {
+			delete(s.entries, key)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"nstorm.com/main-backend/auth"
+	"nstorm.com/main-backend/handlers"
+)
+
+// runCommand handles the maintenance subcommands used to bootstrap access
+// before anyone can log in. It reports whether args named a command.
+//
+//	go run . create-api-key <name>         prints a new service-account key
+//	go run . set-password <employee-id>    reads the password from stdin
+func runCommand(ctx context.Context, pool *pgxpool.Pool, args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+
+	switch args[0] {
+	case "create-api-key":
+		if len(args) != 2 {
+			return true, fmt.Errorf("usage: create-api-key <name>")
+		}
+		apiKey, err := handlers.CreateServiceAPIKey(ctx, pool, args[1])
+		if err != nil {
+			return true, err
+		}
+		fmt.Println(apiKey.Key)
+		return true, nil
+
+	case "set-password":
+		if len(args) != 2 {
+			return true, fmt.Errorf("usage: set-password <employee-id>")
+		}
+		employeeID, err := strconv.Atoi(args[1])
+		if err != nil {
+			return true, fmt.Errorf("invalid employee ID %q", args[1])
+		}
+		password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && password == "" {
+			return true, err
+		}
+		hash, err := auth.HashPassword(strings.TrimRight(password, "\r\n"))
+		if err != nil {
+			return true, err
+		}
+		result, err := pool.Exec(ctx, `UPDATE employees SET password_hash = $1 WHERE id = $2`, hash, employeeID)
+		if err != nil {
+			return true, err
+		}
+		if result.RowsAffected() == 0 {
+			return true, fmt.Errorf("employee %d not found", employeeID)
+		}
+		return true, nil
+	}
+
+	return false, nil
+}
@@ -4,4 +4,13 @@ const axiosInstance = axios.create({
   baseURL: 'http://localhost:8888', // Your backend base URL
 });
 
+// Attach the JWT returned by POST /auth/login
+axiosInstance.interceptors.request.use((config) => {
+  const token = typeof window !== 'undefined' ? window.localStorage.getItem('token') : null;
+  if (token) {
+    config.headers.Authorization = `Bearer ${token}`;
+  }
+  return config;
+});
+
 export default axiosInstance;
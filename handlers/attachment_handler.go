@@ -0,0 +1,491 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"nstorm.com/main-backend/audit"
+	"nstorm.com/main-backend/auth"
+	"nstorm.com/main-backend/blob"
+	"nstorm.com/main-backend/models"
+)
+
+// multipartOverhead is allowed on top of the file size for part headers and
+// boundaries.
+const multipartOverhead = 1 << 20
+
+// maxFileName is the length of attachments.file_name in characters.
+const maxFileName = 255
+
+type AttachmentHandler struct {
+	db     *pgxpool.Pool
+	store  blob.Store
+	policy *auth.Policy
+	audit  *audit.Recorder
+	// maxBytes limits the size of a file; allowedTypes are media types as
+	// sniffed from the content.
+	maxBytes     int64
+	allowedTypes map[string]bool
+}
+
+func NewAttachmentHandler(db *pgxpool.Pool, store blob.Store, policy *auth.Policy, audit *audit.Recorder, maxBytes int64, allowedTypes []string) *AttachmentHandler {
+	allowed := make(map[string]bool, len(allowedTypes))
+	for _, t := range allowedTypes {
+		allowed[strings.ToLower(t)] = true
+	}
+	return &AttachmentHandler{db: db, store: store, policy: policy, audit: audit, maxBytes: maxBytes, allowedTypes: allowed}
+}
+
+const attachmentColumns = `a.id, a.task_id, a.project_id, a.file_name, a.content_type, a.size_bytes, a.sha256,
+               a.uploaded_by_employee_id, a.uploaded_by, a.created_at`
+
+// scanAttachment reads attachmentColumns followed by any extra columns.
+func scanAttachment(row pgx.Row, a *models.Attachment, extra ...any) error {
+	return row.Scan(append([]any{
+		&a.ID,
+		&a.TaskID,
+		&a.ProjectID,
+		&a.FileName,
+		&a.ContentType,
+		&a.SizeBytes,
+		&a.SHA256,
+		&a.UploadedByEmployeeID,
+		&a.UploadedBy,
+		&a.CreatedAt,
+	}, extra...)...)
+}
+
+// attachmentOwner is the task or project an attachment belongs to.
+type attachmentOwner struct {
+	taskID    *int
+	projectID int
+	// assignee of the task, who may attach files to it
+	assignee int
+}
+
+func (o attachmentOwner) storagePrefix() string {
+	if o.taskID != nil {
+		return "tasks/" + strconv.Itoa(*o.taskID)
+	}
+	return "projects/" + strconv.Itoa(o.projectID)
+}
+
+// UploadTaskAttachment stores the "file" part of a multipart/form-data
+// request as an attachment of the task.
+func (h *AttachmentHandler) UploadTaskAttachment(w http.ResponseWriter, r *http.Request) {
+	owner, ok := h.taskOwner(w, r)
+	if !ok {
+		return
+	}
+	h.upload(w, r, owner)
+}
+
+// UploadProjectAttachment stores the "file" part of a multipart/form-data
+// request as an attachment of the project.
+func (h *AttachmentHandler) UploadProjectAttachment(w http.ResponseWriter, r *http.Request) {
+	owner, ok := h.projectOwner(w, r)
+	if !ok {
+		return
+	}
+	h.upload(w, r, owner)
+}
+
+func (h *AttachmentHandler) GetTaskAttachments(w http.ResponseWriter, r *http.Request) {
+	owner, ok := h.taskOwner(w, r)
+	if !ok {
+		return
+	}
+	h.list(w, r, `a.task_id = $1`, *owner.taskID)
+}
+
+func (h *AttachmentHandler) GetProjectAttachments(w http.ResponseWriter, r *http.Request) {
+	owner, ok := h.projectOwner(w, r)
+	if !ok {
+		return
+	}
+	h.list(w, r, `a.project_id = $1`, owner.projectID)
+}
+
+// GetAttachment returns the metadata of an attachment.
+func (h *AttachmentHandler) GetAttachment(w http.ResponseWriter, r *http.Request) {
+	attachment, _, ok := h.loadAttachment(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(attachment)
+}
+
+// DownloadAttachment streams the content of an attachment. The SHA-256 of
+// the content doubles as its ETag.
+func (h *AttachmentHandler) DownloadAttachment(w http.ResponseWriter, r *http.Request) {
+	attachment, key, ok := h.loadAttachment(w, r)
+	if !ok {
+		return
+	}
+
+	etag := `"` + attachment.SHA256 + `"`
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	content, err := h.store.Get(r.Context(), key)
+	if err == blob.ErrNotFound {
+		http.Error(w, "Attachment content is missing", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer content.Close()
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(attachment.SizeBytes, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.FileName}))
+	w.Header().Set("ETag", etag)
+	// Never let a browser render an upload as something else
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if _, err := io.Copy(w, content); err != nil {
+		slog.ErrorContext(r.Context(), "attachment download failed", "attachment_id", attachment.ID, "error", err)
+	}
+}
+
+// DeleteAttachment deletes an attachment and its content. The uploader may
+// delete it, as may anyone allowed to upload to its task or project.
+func (h *AttachmentHandler) DeleteAttachment(w http.ResponseWriter, r *http.Request) {
+	attachment, key, ok := h.loadAttachment(w, r)
+	if !ok {
+		return
+	}
+
+	principal := auth.FromContext(r.Context())
+	uploader := principal != nil && principal.EmployeeID != 0 &&
+		attachment.UploadedByEmployeeID != nil && *attachment.UploadedByEmployeeID == principal.EmployeeID
+	if !uploader {
+		owner := attachmentOwner{taskID: attachment.TaskID}
+		if attachment.ProjectID != nil {
+			owner.projectID = *attachment.ProjectID
+		} else {
+			err := h.db.QueryRow(r.Context(), `SELECT project_id, COALESCE(assigned_to, 0) FROM tasks WHERE id = $1`, *attachment.TaskID).
+				Scan(&owner.projectID, &owner.assignee)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		if !checkAuthorization(w, h.authorizeUpload(r.Context(), principal, owner)) {
+			return
+		}
+	}
+
+	change := h.audit.Begin(r.Context(), audit.Attachment, attachment.ID)
+	if _, err := h.db.Exec(r.Context(), `DELETE FROM attachments WHERE id = $1`, attachment.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	change.Record(r.Context(), audit.Delete)
+
+	// The record is gone; a blob left behind is only wasted space
+	if err := h.store.Delete(context.WithoutCancel(r.Context()), key); err != nil {
+		slog.ErrorContext(r.Context(), "failed to delete attachment content", "key", key, "error", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AttachmentHandler) upload(w http.ResponseWriter, r *http.Request, owner attachmentOwner) {
+	ctx := r.Context()
+	principal := auth.FromContext(ctx)
+	if !checkAuthorization(w, h.authorizeUpload(ctx, principal, owner)) {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxBytes+multipartOverhead)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "Request must be multipart/form-data", http.StatusBadRequest)
+		return
+	}
+
+	var part io.Reader
+	var fileName string
+	for {
+		p, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeUploadError(w, err)
+			return
+		}
+		if p.FormName() == "file" {
+			part, fileName = p, cleanFileName(p.FileName())
+			break
+		}
+	}
+	if part == nil {
+		http.Error(w, "The file part is required", http.StatusBadRequest)
+		return
+	}
+	if fileName == "" {
+		http.Error(w, "The file part needs a file name", http.StatusBadRequest)
+		return
+	}
+
+	// Spool to disk so the size and checksum are known before the content
+	// reaches the store
+	tmp, err := os.CreateTemp("", "attachment-*")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(part, h.maxBytes+1))
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+	if size > h.maxBytes {
+		http.Error(w, fmt.Sprintf("Attachments must not exceed %d bytes", h.maxBytes), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	head := make([]byte, 512)
+	n, err := tmp.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	contentType := http.DetectContentType(head[:n])
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if !h.allowedTypes[mediaType] {
+		http.Error(w, "Attachments of type "+mediaType+" are not allowed", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	attachment := models.Attachment{
+		TaskID:      owner.taskID,
+		FileName:    fileName,
+		ContentType: contentType,
+		SizeBytes:   size,
+		SHA256:      hex.EncodeToString(hash.Sum(nil)),
+		UploadedBy:  principal.String(),
+	}
+	if owner.taskID == nil {
+		attachment.ProjectID = &owner.projectID
+	}
+	if principal.EmployeeID != 0 {
+		attachment.UploadedByEmployeeID = &principal.EmployeeID
+	}
+
+	key := owner.storagePrefix() + "/" + randomKey()
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.store.Put(ctx, key, tmp, size, contentType); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	query := `
+        INSERT INTO attachments (task_id, project_id, file_name, content_type, size_bytes, sha256,
+                                 storage_key, uploaded_by_employee_id, uploaded_by)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+        RETURNING id, created_at`
+
+	err = h.db.QueryRow(ctx, query,
+		attachment.TaskID,
+		attachment.ProjectID,
+		attachment.FileName,
+		attachment.ContentType,
+		attachment.SizeBytes,
+		attachment.SHA256,
+		key,
+		attachment.UploadedByEmployeeID,
+		attachment.UploadedBy,
+	).Scan(&attachment.ID, &attachment.CreatedAt)
+	if err != nil {
+		if err := h.store.Delete(context.WithoutCancel(ctx), key); err != nil {
+			slog.ErrorContext(ctx, "failed to delete attachment content", "key", key, "error", err)
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.audit.Created(audit.Attachment, attachment.ID).Record(ctx, audit.Create)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(attachment)
+}
+
+// authorizeUpload lets principals that manage a task's project, and the
+// task's assignee, attach files to a task. Project attachments need the
+// right to update the project.
+func (h *AttachmentHandler) authorizeUpload(ctx context.Context, principal *auth.Principal, owner attachmentOwner) error {
+	if owner.taskID == nil {
+		return h.policy.Authorize(ctx, principal, auth.UpdateProject, owner.projectID)
+	}
+	err := h.policy.Authorize(ctx, principal, auth.ManageTasks, owner.projectID)
+	if err == auth.ErrForbidden && principal != nil && principal.EmployeeID != 0 && principal.EmployeeID == owner.assignee {
+		return nil
+	}
+	return err
+}
+
+func (h *AttachmentHandler) list(w http.ResponseWriter, r *http.Request, condition string, id int) {
+	query := `
+        SELECT ` + attachmentColumns + `
+        FROM attachments a
+        WHERE ` + condition + `
+        ORDER BY a.created_at, a.id`
+
+	rows, err := h.db.Query(r.Context(), query, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	attachments := []models.Attachment{}
+	for rows.Next() {
+		var attachment models.Attachment
+		if err := scanAttachment(rows, &attachment); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		attachments = append(attachments, attachment)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(attachments)
+}
+
+func (h *AttachmentHandler) taskOwner(w http.ResponseWriter, r *http.Request) (attachmentOwner, bool) {
+	taskID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return attachmentOwner{}, false
+	}
+
+	owner := attachmentOwner{taskID: &taskID}
+	err = h.db.QueryRow(r.Context(), `SELECT project_id, COALESCE(assigned_to, 0) FROM tasks WHERE id = $1`, taskID).
+		Scan(&owner.projectID, &owner.assignee)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return attachmentOwner{}, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return attachmentOwner{}, false
+	}
+	return owner, true
+}
+
+func (h *AttachmentHandler) projectOwner(w http.ResponseWriter, r *http.Request) (attachmentOwner, bool) {
+	projectID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return attachmentOwner{}, false
+	}
+
+	var exists bool
+	if err := h.db.QueryRow(r.Context(), `SELECT EXISTS (SELECT 1 FROM projects WHERE id = $1)`, projectID).Scan(&exists); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return attachmentOwner{}, false
+	}
+	if !exists {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return attachmentOwner{}, false
+	}
+	return attachmentOwner{projectID: projectID}, true
+}
+
+// loadAttachment reads the attachment in the path together with its storage
+// key, which is never sent to clients.
+func (h *AttachmentHandler) loadAttachment(w http.ResponseWriter, r *http.Request) (*models.Attachment, string, bool) {
+	attachmentID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid attachment ID", http.StatusBadRequest)
+		return nil, "", false
+	}
+
+	query := `
+        SELECT ` + attachmentColumns + `, a.storage_key
+        FROM attachments a
+        WHERE a.id = $1`
+
+	var attachment models.Attachment
+	var key string
+	err = scanAttachment(h.db.QueryRow(r.Context(), query, attachmentID), &attachment, &key)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return nil, "", false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, "", false
+	}
+	return &attachment, key, true
+}
+
+// cleanFileName keeps the base name of an uploaded file, without any
+// directory a client may have sent. Long names are shortened before their
+// extension.
+func cleanFileName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	if name == "." || name == ".." || name == "/" {
+		return ""
+	}
+	runes := []rune(name)
+	if len(runes) <= maxFileName {
+		return name
+	}
+	ext := []rune(path.Ext(name))
+	if len(ext) >= maxFileName {
+		ext = nil
+	}
+	return string(runes[:maxFileName-len(ext)]) + string(ext)
+}
+
+func randomKey() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func writeUploadError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestCleanFileName(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "report.pdf", "report.pdf"},
+		{"unix directories", "/home/ann/report.pdf", "report.pdf"},
+		{"windows directories", `C:\Users\ann\report.pdf`, "report.pdf"},
+		{"parent directory", `..\foo`, "foo"},
+		{"parent directories", "../../etc/passwd", "passwd"},
+		{"root", "/", ""},
+		{"empty", "", ""},
+		{"dot dot", "..", ""},
+		{"trailing slash", "docs/", "docs"},
+		{"at the limit", strings.Repeat("a", 251) + ".txt", strings.Repeat("a", 251) + ".txt"},
+		{"long name keeps its extension", strings.Repeat("a", 300) + ".txt", strings.Repeat("a", 251) + ".txt"},
+		{"multibyte name counts runes", strings.Repeat("é", 300) + ".txt", strings.Repeat("é", 251) + ".txt"},
+		{"extension longer than the limit", "a." + strings.Repeat("x", 300), "a." + strings.Repeat("x", 253)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := cleanFileName(tt.in)
+			if got != tt.want {
+				t.Errorf("cleanFileName(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+			if n := utf8.RuneCountInString(got); n > maxFileName {
+				t.Errorf("%d characters, want at most %d", n, maxFileName)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("cleanFileName(%q) is not valid UTF-8", tt.in)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"nstorm.com/main-backend/auth"
+	"nstorm.com/main-backend/models"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+type AuditHandler struct {
+	db     *pgxpool.Pool
+	policy *auth.Policy
+}
+
+func NewAuditHandler(db *pgxpool.Pool, policy *auth.Policy) *AuditHandler {
+	return &AuditHandler{db: db, policy: policy}
+}
+
+// GetAuditEvents lists audit events, newest first. Supported filters are
+// entity_type, entity_id, action, actor_employee_id, request_id, from and to
+// (YYYY-MM-DD or RFC 3339), with limit and offset for paging.
+func (h *AuditHandler) GetAuditEvents(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.policy, auth.ViewAudit, 0) {
+		return
+	}
+
+	params := r.URL.Query()
+	from, err := parseTimeParam(params.Get("from"))
+	if err != nil {
+		http.Error(w, "Invalid from date", http.StatusBadRequest)
+		return
+	}
+	to, err := parseTimeParam(params.Get("to"))
+	if err != nil {
+		http.Error(w, "Invalid to date", http.StatusBadRequest)
+		return
+	}
+	var actorID *int
+	if value := params.Get("actor_employee_id"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "Invalid actor employee ID", http.StatusBadRequest)
+			return
+		}
+		actorID = &id
+	}
+	limit, offset, ok := pageParams(w, r, defaultAuditLimit, maxAuditLimit)
+	if !ok {
+		return
+	}
+
+	query := `
+        SELECT id, occurred_at, actor_employee_id, actor, action, entity_type, entity_id,
+               before, after, diff, COALESCE(request_id, '')
+        FROM audit_events
+        WHERE ($1 = '' OR entity_type = $1)
+          AND ($2 = '' OR entity_id = $2)
+          AND ($3 = '' OR action = $3)
+          AND ($4::integer IS NULL OR actor_employee_id = $4)
+          AND ($5 = '' OR request_id = $5)
+          AND ($6::timestamptz IS NULL OR occurred_at >= $6)
+          AND ($7::timestamptz IS NULL OR occurred_at < $7)
+        ORDER BY occurred_at DESC, id DESC
+        LIMIT $8 OFFSET $9`
+
+	rows, err := h.db.Query(r.Context(), query,
+		params.Get("entity_type"),
+		params.Get("entity_id"),
+		params.Get("action"),
+		actorID,
+		params.Get("request_id"),
+		from,
+		to,
+		limit,
+		offset,
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	events := []models.AuditEvent{}
+	for rows.Next() {
+		var event models.AuditEvent
+		err := rows.Scan(
+			&event.ID,
+			&event.OccurredAt,
+			&event.ActorEmployeeID,
+			&event.Actor,
+			&event.Action,
+			&event.EntityType,
+			&event.EntityID,
+			&event.Before,
+			&event.After,
+			&event.Diff,
+			&event.RequestID,
+		)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		events = append(events, event)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
+
+// pageParams reads ?limit= and ?offset=, writing a 400 for invalid values.
+func pageParams(w http.ResponseWriter, r *http.Request, defaultLimit, maxLimit int) (limit, offset int, ok bool) {
+	limit, offset = defaultLimit, 0
+	params := r.URL.Query()
+	if value := params.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxLimit {
+			http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxLimit), http.StatusBadRequest)
+			return 0, 0, false
+		}
+		limit = n
+	}
+	if value := params.Get("offset"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			http.Error(w, "offset must not be negative", http.StatusBadRequest)
+			return 0, 0, false
+		}
+		offset = n
+	}
+	return limit, offset, true
+}
@@ -0,0 +1,287 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"nstorm.com/main-backend/auth"
+	"nstorm.com/main-backend/models"
+)
+
+type AuthHandler struct {
+	db     *pgxpool.Pool
+	tokens *auth.TokenIssuer
+	policy *auth.Policy
+}
+
+func NewAuthHandler(db *pgxpool.Pool, tokens *auth.TokenIssuer, policy *auth.Policy) *AuthHandler {
+	return &AuthHandler{db: db, tokens: tokens, policy: policy}
+}
+
+// Login exchanges an employee's email and password for a signed JWT
+func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	query := `
+        SELECT id, name, email, role, skills, created_at, COALESCE(password_hash, '')
+        FROM employees
+        WHERE email = $1`
+
+	var employee models.Employee
+	var passwordHash string
+	err := h.db.QueryRow(r.Context(), query, req.Email).Scan(
+		&employee.ID,
+		&employee.Name,
+		&employee.Email,
+		&employee.Role,
+		&employee.Skills,
+		&employee.CreatedAt,
+		&passwordHash,
+	)
+	if err != nil && err != pgx.ErrNoRows {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err == pgx.ErrNoRows || passwordHash == "" || !auth.CheckPassword(passwordHash, req.Password) {
+		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
+		return
+	}
+
+	token, expiresAt, err := h.tokens.Issue(employee)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Token     string          `json:"token"`
+		TokenType string          `json:"token_type"`
+		ExpiresAt time.Time       `json:"expires_at"`
+		Employee  models.Employee `json:"employee"`
+	}{token, "Bearer", expiresAt, employee})
+}
+
+// Me returns the authenticated principal
+func (h *AuthHandler) Me(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(auth.FromContext(r.Context()))
+}
+
+// SetPassword sets an employee's password. Employees changing their own
+// password must supply the current one if they already have one; principals
+// allowed to manage employees may reset anyone's password.
+func (h *AuthHandler) SetPassword(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	employeeID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid employee ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	principal := auth.FromContext(r.Context())
+	self := principal.EmployeeID == employeeID
+	if !self && !authorize(w, r, h.policy, auth.ManageEmployees, 0) {
+		return
+	}
+
+	ctx := r.Context()
+	var currentHash string
+	err = h.db.QueryRow(ctx, `SELECT COALESCE(password_hash, '') FROM employees WHERE id = $1`, employeeID).Scan(&currentHash)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Employee not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if self && currentHash != "" && !auth.CheckPassword(currentHash, req.CurrentPassword) {
+		http.Error(w, "Current password is incorrect", http.StatusForbidden)
+		return
+	}
+
+	hash, err := auth.HashPassword(req.NewPassword)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.db.Exec(ctx, `UPDATE employees SET password_hash = $1 WHERE id = $2`, hash, employeeID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateAPIKey issues a long-lived API key. Keys act on behalf of the
+// employee creating them unless "service" is set, which creates a
+// service-account key and requires the right to manage service accounts.
+// The key is only returned in this response.
+func (h *AuthHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name      string     `json:"name"`
+		Service   bool       `json:"service"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+
+	principal := auth.FromContext(r.Context())
+	var employeeID *int
+	if req.Service || principal.Service {
+		if !authorize(w, r, h.policy, auth.ManageServiceAccounts, 0) {
+			return
+		}
+	} else {
+		employeeID = &principal.EmployeeID
+	}
+
+	apiKey, err := createAPIKey(r.Context(), h.db, req.Name, employeeID, req.ExpiresAt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(apiKey)
+}
+
+// GetAPIKeys lists the caller's API keys; service accounts see the keys that
+// belong to no employee
+func (h *AuthHandler) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	principal := auth.FromContext(r.Context())
+	query := `
+        SELECT id, name, key_prefix, employee_id, created_at, expires_at, last_used_at, revoked_at
+        FROM api_keys
+        WHERE ($1 AND employee_id IS NULL) OR employee_id = $2
+        ORDER BY id`
+
+	rows, err := h.db.Query(r.Context(), query, principal.Service, principal.EmployeeID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		var key models.APIKey
+		err := rows.Scan(
+			&key.ID,
+			&key.Name,
+			&key.Prefix,
+			&key.EmployeeID,
+			&key.CreatedAt,
+			&key.ExpiresAt,
+			&key.LastUsedAt,
+			&key.RevokedAt,
+		)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// RevokeAPIKey revokes one of the caller's API keys; service accounts may
+// revoke keys that belong to no employee
+func (h *AuthHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	keyID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid API key ID", http.StatusBadRequest)
+		return
+	}
+
+	principal := auth.FromContext(r.Context())
+	query := `
+        UPDATE api_keys
+        SET revoked_at = CURRENT_TIMESTAMP
+        WHERE id = $1 AND revoked_at IS NULL AND (($2 AND employee_id IS NULL) OR employee_id = $3)`
+
+	result, err := h.db.Exec(r.Context(), query, keyID, principal.Service, principal.EmployeeID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if result.RowsAffected() == 0 {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// createAPIKey stores a new API key and returns it with the plaintext key
+// filled in.
+func createAPIKey(ctx context.Context, db *pgxpool.Pool, name string, employeeID *int, expiresAt *time.Time) (*models.APIKey, error) {
+	key, hash, prefix, err := auth.NewAPIKey()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+        INSERT INTO api_keys (name, key_prefix, key_hash, employee_id, expires_at)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING id, name, key_prefix, employee_id, created_at, expires_at`
+
+	apiKey := models.APIKey{Key: key}
+	err = db.QueryRow(ctx, query, name, prefix, hash, employeeID, expiresAt).Scan(
+		&apiKey.ID,
+		&apiKey.Name,
+		&apiKey.Prefix,
+		&apiKey.EmployeeID,
+		&apiKey.CreatedAt,
+		&apiKey.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &apiKey, nil
+}
+
+// CreateServiceAPIKey creates an API key for a service account. It backs the
+// create-api-key command used to bootstrap access.
+func CreateServiceAPIKey(ctx context.Context, db *pgxpool.Pool, name string) (*models.APIKey, error) {
+	return createAPIKey(ctx, db, name, nil, nil)
+}
@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"net/http"
+
+	"nstorm.com/main-backend/auth"
+)
+
+// authorize checks action against the caller's principal and writes a 403 if
+// it is not allowed. It reports whether the handler may continue.
+func authorize(w http.ResponseWriter, r *http.Request, policy *auth.Policy, action auth.Action, projectID int) bool {
+	return checkAuthorization(w, policy.Authorize(r.Context(), auth.FromContext(r.Context()), action, projectID))
+}
+
+func checkAuthorization(w http.ResponseWriter, err error) bool {
+	if err == auth.ErrForbidden {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,652 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"nstorm.com/main-backend/audit"
+	"nstorm.com/main-backend/auth"
+	"nstorm.com/main-backend/models"
+)
+
+const (
+	defaultCommentLimit = 50
+	maxCommentLimit     = 200
+	maxCommentLength    = 20000
+)
+
+type CommentHandler struct {
+	db     *pgxpool.Pool
+	policy *auth.Policy
+	audit  *audit.Recorder
+}
+
+func NewCommentHandler(db *pgxpool.Pool, policy *auth.Policy, audit *audit.Recorder) *CommentHandler {
+	return &CommentHandler{db: db, policy: policy, audit: audit}
+}
+
+// commentColumns is the select list read by scanComment, for queries that
+// alias task_comments as c.
+const commentColumns = `c.id, c.task_id, c.parent_comment_id, c.author_employee_id, c.author, c.body,
+               c.deleted_at IS NOT NULL, c.created_at, c.updated_at`
+
+func scanComment(row pgx.Row, comment *models.TaskComment) error {
+	comment.Mentions = []models.CommentMention{}
+	return row.Scan(
+		&comment.ID,
+		&comment.TaskID,
+		&comment.ParentCommentID,
+		&comment.AuthorEmployeeID,
+		&comment.Author,
+		&comment.Body,
+		&comment.Deleted,
+		&comment.CreatedAt,
+		&comment.UpdatedAt,
+	)
+}
+
+func scanComments(rows pgx.Rows) ([]models.TaskComment, error) {
+	defer rows.Close()
+
+	var comments []models.TaskComment
+	for rows.Next() {
+		var comment models.TaskComment
+		if err := scanComment(rows, &comment); err != nil {
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+	return comments, rows.Err()
+}
+
+// GetTaskComments lists the threads on a task, oldest first, with limit and
+// offset paging over the top-level comments. Every reply of a thread is
+// nested under its top-level comment.
+func (h *CommentHandler) GetTaskComments(w http.ResponseWriter, r *http.Request) {
+	taskID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return
+	}
+	limit, offset, ok := pageParams(w, r, defaultCommentLimit, maxCommentLimit)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	var exists bool
+	if err := h.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM tasks WHERE id = $1)`, taskID).Scan(&exists); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+
+	query := `
+        SELECT ` + commentColumns + `
+        FROM task_comments c
+        WHERE c.task_id = $1 AND c.parent_comment_id IS NULL
+        ORDER BY c.created_at, c.id
+        LIMIT $2 OFFSET $3`
+
+	rows, err := h.db.Query(ctx, query, taskID, limit, offset)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	comments, err := scanComments(rows)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if comments == nil {
+		comments = []models.TaskComment{}
+	}
+	if err := h.withReplies(ctx, comments); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(comments)
+}
+
+// GetTaskComment returns one comment with the replies below it.
+func (h *CommentHandler) GetTaskComment(w http.ResponseWriter, r *http.Request) {
+	taskID, commentID, ok := commentParams(w, r)
+	if !ok {
+		return
+	}
+
+	comment, err := h.loadComment(r.Context(), taskID, commentID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	comments := []models.TaskComment{*comment}
+	if err := h.withReplies(r.Context(), comments); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(comments[0])
+}
+
+// CreateTaskComment adds a comment to a task, or a reply when
+// parent_comment_id is set. The caller is the author; @mentions of
+// employees are resolved from the body.
+func (h *CommentHandler) CreateTaskComment(w http.ResponseWriter, r *http.Request) {
+	taskID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return
+	}
+
+	var comment models.TaskComment
+	if err := json.NewDecoder(r.Body).Decode(&comment); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateCommentBody(comment.Body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	var exists bool
+	if err := h.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM tasks WHERE id = $1)`, taskID).Scan(&exists); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+
+	if comment.ParentCommentID != nil {
+		parent, err := h.loadComment(ctx, taskID, *comment.ParentCommentID)
+		if err == pgx.ErrNoRows {
+			http.Error(w, "Parent comment not found on this task", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if parent.Deleted {
+			http.Error(w, "Cannot reply to a deleted comment", http.StatusConflict)
+			return
+		}
+	}
+
+	principal := auth.FromContext(ctx)
+	comment.Author = commentAuthor(principal)
+	if principal.EmployeeID != 0 {
+		comment.AuthorEmployeeID = &principal.EmployeeID
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+        INSERT INTO task_comments (task_id, parent_comment_id, author_employee_id, author, body)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING ` + commentColumns
+
+	err = scanComment(tx.QueryRow(ctx, query,
+		taskID,
+		comment.ParentCommentID,
+		comment.AuthorEmployeeID,
+		comment.Author,
+		comment.Body,
+	), &comment)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if comment.Mentions, err = saveMentions(ctx, tx, comment.ID, comment.Body); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit.In(tx).Created(audit.Comment, comment.ID).Record(ctx, audit.Create)
+
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(comment)
+}
+
+// UpdateTaskComment replaces the body of a comment. Only its author may
+// edit it; the previous body is kept in the comment's history.
+func (h *CommentHandler) UpdateTaskComment(w http.ResponseWriter, r *http.Request) {
+	taskID, commentID, ok := commentParams(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Body string `json:"body"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateCommentBody(req.Body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	comment, err := h.loadComment(ctx, taskID, commentID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if comment.Deleted {
+		http.Error(w, "Comment was deleted", http.StatusConflict)
+		return
+	}
+	principal := auth.FromContext(ctx)
+	if !isCommentAuthor(principal, comment) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if req.Body != comment.Body {
+		tx, err := h.db.Begin(ctx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		change := h.audit.In(tx).Begin(ctx, audit.Comment, commentID)
+		query := `
+        INSERT INTO task_comment_revisions (comment_id, body, edited_by)
+        VALUES ($1, $2, $3)`
+		if _, err := tx.Exec(ctx, query, commentID, comment.Body, principal.String()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		query = `
+        UPDATE task_comments c
+        SET body = $1, updated_at = CURRENT_TIMESTAMP
+        WHERE c.id = $2
+        RETURNING ` + commentColumns
+		if err := scanComment(tx.QueryRow(ctx, query, req.Body, commentID), comment); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if comment.Mentions, err = saveMentions(ctx, tx, commentID, comment.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		change.Record(ctx, audit.Update)
+
+		if err := tx.Commit(ctx); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	} else if err := h.loadMentions(ctx, []*models.TaskComment{comment}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(comment)
+}
+
+// DeleteTaskComment deletes a comment. Its author and anyone allowed to
+// manage the task's project may delete it. A comment with replies is only
+// marked deleted and emptied so that the thread stays readable.
+func (h *CommentHandler) DeleteTaskComment(w http.ResponseWriter, r *http.Request) {
+	taskID, commentID, ok := commentParams(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	comment, err := h.loadComment(ctx, taskID, commentID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if comment.Deleted {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if !isCommentAuthor(auth.FromContext(ctx), comment) {
+		var projectID int
+		if err := h.db.QueryRow(ctx, `SELECT project_id FROM tasks WHERE id = $1`, taskID).Scan(&projectID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !authorize(w, r, h.policy, auth.ManageTasks, projectID) {
+			return
+		}
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	change := h.audit.In(tx).Begin(ctx, audit.Comment, commentID)
+	query := `
+        WITH kept AS (
+            UPDATE task_comments
+            SET body = '', deleted_at = CURRENT_TIMESTAMP
+            WHERE id = $1 AND EXISTS (SELECT 1 FROM task_comments WHERE parent_comment_id = $1)
+            RETURNING id
+        )
+        DELETE FROM task_comment_mentions WHERE comment_id IN (SELECT id FROM kept)`
+	if _, err := tx.Exec(ctx, query, commentID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM task_comments WHERE id = $1 AND deleted_at IS NULL`, commentID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	change.Record(ctx, audit.Delete)
+
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetCommentHistory lists the earlier bodies of a comment, oldest first.
+// Each revision records who replaced it and when.
+func (h *CommentHandler) GetCommentHistory(w http.ResponseWriter, r *http.Request) {
+	taskID, commentID, ok := commentParams(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	if _, err := h.loadComment(ctx, taskID, commentID); err == pgx.ErrNoRows {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	query := `
+        SELECT id, comment_id, body, edited_by, edited_at
+        FROM task_comment_revisions
+        WHERE comment_id = $1
+        ORDER BY edited_at, id`
+
+	rows, err := h.db.Query(ctx, query, commentID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	revisions := []models.CommentRevision{}
+	for rows.Next() {
+		var revision models.CommentRevision
+		if err := rows.Scan(&revision.ID, &revision.CommentID, &revision.Body, &revision.EditedBy, &revision.EditedAt); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		revisions = append(revisions, revision)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(revisions)
+}
+
+func (h *CommentHandler) loadComment(ctx context.Context, taskID, commentID int) (*models.TaskComment, error) {
+	query := `
+        SELECT ` + commentColumns + `
+        FROM task_comments c
+        WHERE c.id = $1 AND c.task_id = $2`
+
+	var comment models.TaskComment
+	if err := scanComment(h.db.QueryRow(ctx, query, commentID, taskID), &comment); err != nil {
+		return nil, err
+	}
+	return &comment, nil
+}
+
+// withReplies loads the replies below each of comments, at any depth, and
+// the mentions of all of them.
+func (h *CommentHandler) withReplies(ctx context.Context, comments []models.TaskComment) error {
+	if len(comments) == 0 {
+		return nil
+	}
+	ids := make([]int, len(comments))
+	for i, comment := range comments {
+		ids[i] = comment.ID
+	}
+
+	query := `
+        WITH RECURSIVE thread(id) AS (
+            SELECT id FROM task_comments WHERE parent_comment_id = ANY($1)
+            UNION ALL
+            SELECT c.id
+            FROM task_comments c
+            JOIN thread ON c.parent_comment_id = thread.id
+        )
+        SELECT ` + commentColumns + `
+        FROM task_comments c
+        JOIN thread ON thread.id = c.id
+        ORDER BY c.id`
+
+	rows, err := h.db.Query(ctx, query, ids)
+	if err != nil {
+		return err
+	}
+	replies, err := scanComments(rows)
+	if err != nil {
+		return err
+	}
+
+	// Replies are ordered by ID, so a reply's parent has been seen before it
+	root := make(map[int]int, len(comments)+len(replies))
+	index := make(map[int]int, len(comments))
+	for i, comment := range comments {
+		root[comment.ID] = comment.ID
+		index[comment.ID] = i
+	}
+	for _, reply := range replies {
+		top := root[*reply.ParentCommentID]
+		root[reply.ID] = top
+		comments[index[top]].Replies = append(comments[index[top]].Replies, reply)
+	}
+
+	all := make([]*models.TaskComment, 0, len(comments)+len(replies))
+	for i := range comments {
+		all = append(all, &comments[i])
+		for j := range comments[i].Replies {
+			all = append(all, &comments[i].Replies[j])
+		}
+	}
+	return h.loadMentions(ctx, all)
+}
+
+func (h *CommentHandler) loadMentions(ctx context.Context, comments []*models.TaskComment) error {
+	byID := make(map[int]*models.TaskComment, len(comments))
+	ids := make([]int, 0, len(comments))
+	for _, comment := range comments {
+		byID[comment.ID] = comment
+		ids = append(ids, comment.ID)
+	}
+
+	query := `
+        SELECT m.comment_id, e.id, e.name
+        FROM task_comment_mentions m
+        JOIN employees e ON e.id = m.employee_id
+        WHERE m.comment_id = ANY($1)
+        ORDER BY e.name, e.id`
+
+	rows, err := h.db.Query(ctx, query, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var commentID int
+		var mention models.CommentMention
+		if err := rows.Scan(&commentID, &mention.EmployeeID, &mention.Name); err != nil {
+			return err
+		}
+		byID[commentID].Mentions = append(byID[commentID].Mentions, mention)
+	}
+	return rows.Err()
+}
+
+var (
+	// Code spans and blocks are skipped when looking for mentions
+	codePattern = regexp.MustCompile("(?s)```.*?```|`[^`\n]*`")
+	// A mention is @ followed by an email address or the part of one before
+	// the @, e.g. @jane.doe or @jane.doe@example.com
+	mentionPattern = regexp.MustCompile(`(?:^|[^\w@])@([\w.+-]+(?:@[\w-]+(?:\.[\w-]+)+)?)`)
+)
+
+// parseMentions returns the lower-cased handles mentioned in body.
+func parseMentions(body string) []string {
+	body = codePattern.ReplaceAllString(body, " ")
+
+	seen := make(map[string]bool)
+	handles := []string{}
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		handle := strings.ToLower(strings.TrimRight(match[1], "."))
+		if handle != "" && !seen[handle] {
+			seen[handle] = true
+			handles = append(handles, handle)
+		}
+	}
+	return handles
+}
+
+// saveMentions replaces the mentions of a comment with the employees
+// mentioned in body and returns them. Handles that match no employee are
+// ignored.
+func saveMentions(ctx context.Context, db querier, commentID int, body string) ([]models.CommentMention, error) {
+	if _, err := db.Exec(ctx, `DELETE FROM task_comment_mentions WHERE comment_id = $1`, commentID); err != nil {
+		return nil, err
+	}
+
+	mentions := []models.CommentMention{}
+	handles := parseMentions(body)
+	if len(handles) == 0 {
+		return mentions, nil
+	}
+
+	query := `
+        WITH mentioned AS (
+            INSERT INTO task_comment_mentions (comment_id, employee_id)
+            SELECT $1, id
+            FROM employees
+            WHERE lower(email) = ANY($2) OR lower(split_part(email, '@', 1)) = ANY($2)
+            RETURNING employee_id
+        )
+        SELECT e.id, e.name
+        FROM mentioned m
+        JOIN employees e ON e.id = m.employee_id
+        ORDER BY e.name, e.id`
+
+	rows, err := db.Query(ctx, query, commentID, handles)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var mention models.CommentMention
+		if err := rows.Scan(&mention.EmployeeID, &mention.Name); err != nil {
+			return nil, err
+		}
+		mentions = append(mentions, mention)
+	}
+	return mentions, rows.Err()
+}
+
+func validateCommentBody(body string) error {
+	if strings.TrimSpace(body) == "" {
+		return fmt.Errorf("Comment body is required")
+	}
+	if utf8.RuneCountInString(body) > maxCommentLength {
+		return fmt.Errorf("Comment body must not exceed %d characters", maxCommentLength)
+	}
+	return nil
+}
+
+// commentAuthor is the name shown on comments written by principal. For
+// service accounts it includes the API key, which identifies the author.
+func commentAuthor(principal *auth.Principal) string {
+	if principal.Service {
+		return principal.Name + " (" + principal.String() + ")"
+	}
+	return principal.Name
+}
+
+func isCommentAuthor(principal *auth.Principal, comment *models.TaskComment) bool {
+	if principal == nil {
+		return false
+	}
+	if principal.Service {
+		return comment.AuthorEmployeeID == nil && comment.Author == commentAuthor(principal)
+	}
+	return comment.AuthorEmployeeID != nil && *comment.AuthorEmployeeID == principal.EmployeeID
+}
+
+func commentParams(w http.ResponseWriter, r *http.Request) (taskID, commentID int, ok bool) {
+	vars := mux.Vars(r)
+	taskID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	commentID, err = strconv.Atoi(vars["commentId"])
+	if err != nil {
+		http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	return taskID, commentID, true
+}
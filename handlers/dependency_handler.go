@@ -0,0 +1,430 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"nstorm.com/main-backend/audit"
+	"nstorm.com/main-backend/auth"
+	"nstorm.com/main-backend/models"
+)
+
+// querier is satisfied by both the pool and a transaction.
+type querier interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+type DependencyHandler struct {
+	db     *pgxpool.Pool
+	policy *auth.Policy
+	audit  *audit.Recorder
+	// allowCrossProject permits dependencies between tasks of different
+	// projects.
+	allowCrossProject bool
+}
+
+func NewDependencyHandler(db *pgxpool.Pool, policy *auth.Policy, audit *audit.Recorder, allowCrossProject bool) *DependencyHandler {
+	return &DependencyHandler{db: db, policy: policy, audit: audit, allowCrossProject: allowCrossProject}
+}
+
+// GetTaskDependencies lists the prerequisites of a task and its dependents
+func (h *DependencyHandler) GetTaskDependencies(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	taskID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return
+	}
+
+	var exists bool
+	err = h.db.QueryRow(r.Context(), `SELECT EXISTS (SELECT 1 FROM tasks WHERE id = $1)`, taskID).Scan(&exists)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+
+	dependencies := models.TaskDependencies{TaskID: taskID, DependsOn: []models.Task{}, Dependents: []models.Task{}}
+
+	query := `
+        SELECT ` + taskColumns + `
+        FROM tasks t
+        JOIN task_dependencies d ON d.depends_on_id = t.id
+        WHERE d.task_id = $1
+        ORDER BY t.id`
+
+	rows, err := h.db.Query(r.Context(), query, taskID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if dependencies.DependsOn, err = scanTasks(rows); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	query = `
+        SELECT ` + taskColumns + `
+        FROM tasks t
+        JOIN task_dependencies d ON d.task_id = t.id
+        WHERE d.depends_on_id = $1
+        ORDER BY t.id`
+
+	rows, err = h.db.Query(r.Context(), query, taskID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if dependencies.Dependents, err = scanTasks(rows); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dependencies)
+}
+
+// AddTaskDependency makes a task depend on another. Links that would close
+// a cycle are rejected, as are links across projects unless allowed. An
+// existing link is returned as is with 200.
+func (h *DependencyHandler) AddTaskDependency(w http.ResponseWriter, r *http.Request) {
+	taskID, dependsOnID, ok := dependencyParams(w, r)
+	if !ok {
+		return
+	}
+	if taskID == dependsOnID {
+		http.Error(w, "A task cannot depend on itself", http.StatusBadRequest)
+		return
+	}
+
+	var taskProject, dependsOnProject *int
+	query := `
+        SELECT (SELECT project_id FROM tasks WHERE id = $1), (SELECT project_id FROM tasks WHERE id = $2)`
+	err := h.db.QueryRow(r.Context(), query, taskID, dependsOnID).Scan(&taskProject, &dependsOnProject)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if taskProject == nil || dependsOnProject == nil {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+
+	if *taskProject != *dependsOnProject && !h.allowCrossProject {
+		http.Error(w, "Dependencies between tasks of different projects are not allowed", http.StatusBadRequest)
+		return
+	}
+	if !authorize(w, r, h.policy, auth.ManageTasks, *taskProject) {
+		return
+	}
+
+	tx, err := h.db.Begin(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	// Serialise dependency changes so two concurrent links cannot form a
+	// cycle that neither check sees
+	if _, err := tx.Exec(r.Context(), `LOCK TABLE task_dependencies IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	cycle, err := dependsOn(r.Context(), tx, dependsOnID, taskID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if cycle {
+		http.Error(w, "Dependency would create a cycle", http.StatusConflict)
+		return
+	}
+
+	recorder := h.audit.In(tx)
+	change := recorder.Begin(r.Context(), audit.Dependency, taskID, dependsOnID)
+
+	var dependency models.TaskDependency
+	query = `
+        INSERT INTO task_dependencies (task_id, depends_on_id)
+        VALUES ($1, $2)
+        ON CONFLICT (task_id, depends_on_id) DO NOTHING
+        RETURNING task_id, depends_on_id, created_at`
+
+	err = tx.QueryRow(r.Context(), query, taskID, dependsOnID).Scan(
+		&dependency.TaskID,
+		&dependency.DependsOnID,
+		&dependency.CreatedAt,
+	)
+	if err == pgx.ErrNoRows {
+		query = `
+        SELECT task_id, depends_on_id, created_at
+        FROM task_dependencies
+        WHERE task_id = $1 AND depends_on_id = $2`
+
+		err = tx.QueryRow(r.Context(), query, taskID, dependsOnID).Scan(
+			&dependency.TaskID,
+			&dependency.DependsOnID,
+			&dependency.CreatedAt,
+		)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(dependency)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	change.Record(r.Context(), audit.Create)
+
+	if _, err := refreshBlockedStatus(r.Context(), tx, recorder, []int{taskID}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(dependency)
+}
+
+// RemoveTaskDependency removes a dependency and unblocks the task if it has
+// no other open prerequisites
+func (h *DependencyHandler) RemoveTaskDependency(w http.ResponseWriter, r *http.Request) {
+	taskID, dependsOnID, ok := dependencyParams(w, r)
+	if !ok {
+		return
+	}
+
+	var projectID int
+	err := h.db.QueryRow(r.Context(), `SELECT project_id FROM tasks WHERE id = $1`, taskID).Scan(&projectID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !authorize(w, r, h.policy, auth.ManageTasks, projectID) {
+		return
+	}
+
+	tx, err := h.db.Begin(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	recorder := h.audit.In(tx)
+	change := recorder.Begin(r.Context(), audit.Dependency, taskID, dependsOnID)
+	result, err := tx.Exec(r.Context(), `DELETE FROM task_dependencies WHERE task_id = $1 AND depends_on_id = $2`, taskID, dependsOnID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected() == 0 {
+		http.Error(w, "Dependency not found", http.StatusNotFound)
+		return
+	}
+	change.Record(r.Context(), audit.Delete)
+
+	if _, err := refreshBlockedStatus(r.Context(), tx, recorder, []int{taskID}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetDependencyGraph returns the tasks of a project as nodes and their
+// dependencies as edges
+func (h *DependencyHandler) GetDependencyGraph(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	projectID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	graph := models.DependencyGraph{ProjectID: projectID, Nodes: []models.DependencyNode{}, Edges: []models.DependencyEdge{}}
+
+	query := `
+        SELECT d.depends_on_id, d.task_id
+        FROM task_dependencies d
+        JOIN tasks t ON t.id = d.task_id
+        JOIN tasks p ON p.id = d.depends_on_id
+        WHERE t.project_id = $1 OR p.project_id = $1
+        ORDER BY d.depends_on_id, d.task_id`
+
+	rows, err := h.db.Query(r.Context(), query, projectID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var edge models.DependencyEdge
+		if err := rows.Scan(&edge.From, &edge.To); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		graph.Edges = append(graph.Edges, edge)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The project's own tasks plus any task of another project linked to them
+	query = `
+        SELECT ` + taskColumns + `
+        FROM tasks t
+        WHERE t.project_id = $1
+           OR t.id IN (
+               SELECT d.depends_on_id FROM task_dependencies d JOIN tasks x ON x.id = d.task_id WHERE x.project_id = $1
+               UNION
+               SELECT d.task_id FROM task_dependencies d JOIN tasks x ON x.id = d.depends_on_id WHERE x.project_id = $1)
+        ORDER BY t.id`
+
+	rows, err = h.db.Query(r.Context(), query, projectID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tasks, err := scanTasks(rows)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, task := range tasks {
+		graph.Nodes = append(graph.Nodes, models.DependencyNode{Task: task, External: task.ProjectID != projectID})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(graph)
+}
+
+func dependencyParams(w http.ResponseWriter, r *http.Request) (taskID, dependsOnID int, ok bool) {
+	vars := mux.Vars(r)
+	taskID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	dependsOnID, err = strconv.Atoi(vars["dependsOnId"])
+	if err != nil {
+		http.Error(w, "Invalid dependency task ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	return taskID, dependsOnID, true
+}
+
+// dependsOn reports whether taskID transitively depends on prerequisiteID.
+func dependsOn(ctx context.Context, db querier, taskID, prerequisiteID int) (bool, error) {
+	query := `
+        WITH RECURSIVE prerequisites(id) AS (
+            SELECT depends_on_id FROM task_dependencies WHERE task_id = $1
+            UNION
+            SELECT d.depends_on_id
+            FROM task_dependencies d
+            JOIN prerequisites p ON d.task_id = p.id
+        )
+        SELECT EXISTS (SELECT 1 FROM prerequisites WHERE id = $2)`
+
+	var found bool
+	err := db.QueryRow(ctx, query, taskID, prerequisiteID).Scan(&found)
+	return found, err
+}
+
+// dependentsOf returns the tasks that directly depend on taskID.
+func dependentsOf(ctx context.Context, db querier, taskID int) ([]int, error) {
+	rows, err := db.Query(ctx, `SELECT task_id FROM task_dependencies WHERE depends_on_id = $1`, taskID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[int])
+}
+
+// refreshBlockedStatus moves each of taskIDs that is not done to BLOCKED
+// while it has open prerequisites, and back to the status it had before once
+// it has none. It returns the new status of every task it changed.
+func refreshBlockedStatus(ctx context.Context, db querier, recorder *audit.Recorder, taskIDs []int) (map[int]string, error) {
+	query := `
+        SELECT t.id, b.blocked
+        FROM tasks t
+        CROSS JOIN LATERAL (
+            SELECT EXISTS (
+                SELECT 1
+                FROM task_dependencies d
+                JOIN tasks p ON p.id = d.depends_on_id
+                WHERE d.task_id = t.id AND p.status <> 'DONE') AS blocked
+        ) b
+        WHERE t.id = ANY($1)
+          AND t.status <> 'DONE'
+          AND (t.status = 'BLOCKED') <> b.blocked`
+
+	rows, err := db.Query(ctx, query, taskIDs)
+	if err != nil {
+		return nil, err
+	}
+	blocked := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		var isBlocked bool
+		if err := rows.Scan(&id, &isBlocked); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		blocked[id] = isBlocked
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// The status a task had when it was blocked is kept to restore it
+	query = `
+        UPDATE tasks
+        SET status = CASE WHEN $2 THEN 'BLOCKED' ELSE COALESCE(status_before_blocked, 'TODO') END,
+            status_before_blocked = CASE WHEN $2 THEN status END
+        WHERE id = $1
+        RETURNING status`
+
+	changes := make(map[int]string)
+	for id, isBlocked := range blocked {
+		change := recorder.Begin(ctx, audit.Task, id)
+		var status string
+		if err := db.QueryRow(ctx, query, id, isBlocked).Scan(&status); err != nil {
+			return nil, err
+		}
+		change.Record(ctx, audit.Update)
+		changes[id] = status
+	}
+	return changes, nil
+}
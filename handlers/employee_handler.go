@@ -1,25 +1,34 @@
 package handlers
 
 import (
-	"context"
 	"encoding/json"
+	"io"
 	"net/http"
 	"strconv"
 
 	"github.com/gorilla/mux"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"nstorm.com/main-backend/audit"
+	"nstorm.com/main-backend/auth"
 	"nstorm.com/main-backend/models"
 )
 
 type EmployeeHandler struct {
-	db *pgx.Conn
+	db     *pgxpool.Pool
+	policy *auth.Policy
+	audit  *audit.Recorder
 }
 
-func NewEmployeeHandler(db *pgx.Conn) *EmployeeHandler {
-	return &EmployeeHandler{db: db}
+func NewEmployeeHandler(db *pgxpool.Pool, policy *auth.Policy, audit *audit.Recorder) *EmployeeHandler {
+	return &EmployeeHandler{db: db, policy: policy, audit: audit}
 }
 
 func (h *EmployeeHandler) CreateEmployee(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.policy, auth.ManageEmployees, 0) {
+		return
+	}
+
 	var employee models.Employee
 	if err := json.NewDecoder(r.Body).Decode(&employee); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -32,7 +41,7 @@ func (h *EmployeeHandler) CreateEmployee(w http.ResponseWriter, r *http.Request)
         RETURNING id, name, email, role, skills, created_at`
 
 	err := h.db.QueryRow(
-		context.Background(),
+		r.Context(),
 		query,
 		employee.Name,
 		employee.Email,
@@ -52,6 +61,8 @@ func (h *EmployeeHandler) CreateEmployee(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	h.audit.Created(audit.Employee, employee.ID).Record(r.Context(), audit.Create)
+
 	json.NewEncoder(w).Encode(employee)
 }
 
@@ -69,7 +80,7 @@ func (h *EmployeeHandler) GetEmployeeById(w http.ResponseWriter, r *http.Request
         FROM employees
         WHERE id = $1`
 
-	err = h.db.QueryRow(context.Background(), query, id).Scan(
+	err = h.db.QueryRow(r.Context(), query, id).Scan(
 		&employee.ID,
 		&employee.Name,
 		&employee.Email,
@@ -95,7 +106,7 @@ func (h *EmployeeHandler) GetAllEmployees(w http.ResponseWriter, r *http.Request
         SELECT id, name, email, role, skills, created_at
         FROM employees;`
 
-	rows, err := h.db.Query(context.Background(), query)
+	rows, err := h.db.Query(r.Context(), query)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -131,6 +142,10 @@ func (h *EmployeeHandler) UpdateEmployee(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if !authorize(w, r, h.policy, auth.ManageEmployees, 0) {
+		return
+	}
+
 	var employee models.Employee
 	if err := json.NewDecoder(r.Body).Decode(&employee); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -143,8 +158,9 @@ func (h *EmployeeHandler) UpdateEmployee(w http.ResponseWriter, r *http.Request)
         WHERE id = $5
         RETURNING id, name, email, role, skills, created_at`
 
+	change := h.audit.Begin(r.Context(), audit.Employee, id)
 	err = h.db.QueryRow(
-		context.Background(),
+		r.Context(),
 		query,
 		employee.Name,
 		employee.Email,
@@ -169,6 +185,8 @@ func (h *EmployeeHandler) UpdateEmployee(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	change.Record(r.Context(), audit.Update)
+
 	json.NewEncoder(w).Encode(employee)
 }
 func (h *EmployeeHandler) DeleteEmployee(w http.ResponseWriter, r *http.Request) {
@@ -179,8 +197,13 @@ func (h *EmployeeHandler) DeleteEmployee(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if !authorize(w, r, h.policy, auth.ManageEmployees, 0) {
+		return
+	}
+
+	change := h.audit.Begin(r.Context(), audit.Employee, id)
 	query := `DELETE FROM employees WHERE id = $1`
-	result, err := h.db.Exec(context.Background(), query, id)
+	result, err := h.db.Exec(r.Context(), query, id)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -191,6 +214,8 @@ func (h *EmployeeHandler) DeleteEmployee(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	change.Record(r.Context(), audit.Delete)
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -202,35 +227,26 @@ func (h *EmployeeHandler) GetEmployeeTasks(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	filter, err := parseTaskFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.where("t.assigned_to = $%d", employeeId)
+
 	query := `
-        SELECT t.id, t.project_id, t.assigned_to, t.title, t.description, t.status, t.created_at
-        FROM tasks t
-        WHERE t.assigned_to = $1`
+        SELECT ` + taskColumns + `
+        FROM tasks t` + filter.clause()
 
-	rows, err := h.db.Query(context.Background(), query, employeeId)
+	rows, err := h.db.Query(r.Context(), query, filter.args...)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	var tasks []models.Task
-	for rows.Next() {
-		var task models.Task
-		err := rows.Scan(
-			&task.ID,
-			&task.ProjectID,
-			&task.AssignedTo,
-			&task.Title,
-			&task.Description,
-			&task.Status,
-			&task.CreatedAt,
-		)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		tasks = append(tasks, task)
+	tasks, err := scanTasks(rows)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	json.NewEncoder(w).Encode(tasks)
@@ -249,35 +265,27 @@ func (h *EmployeeHandler) GetEmployeeTasksByStatus(w http.ResponseWriter, r *htt
 		return
 	}
 
+	filter, err := parseTaskFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.where("t.assigned_to = $%d", employeeId)
+	filter.where("t.status = $%d", status)
+
 	query := `
-        SELECT t.id, t.project_id, t.assigned_to, t.title, t.description, t.status, t.created_at
-        FROM tasks t
-        WHERE t.assigned_to = $1 AND t.status = $2`
+        SELECT ` + taskColumns + `
+        FROM tasks t` + filter.clause()
 
-	rows, err := h.db.Query(context.Background(), query, employeeId, status)
+	rows, err := h.db.Query(r.Context(), query, filter.args...)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	var tasks []models.Task
-	for rows.Next() {
-		var task models.Task
-		err := rows.Scan(
-			&task.ID,
-			&task.ProjectID,
-			&task.AssignedTo,
-			&task.Title,
-			&task.Description,
-			&task.Status,
-			&task.CreatedAt,
-		)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		tasks = append(tasks, task)
+	tasks, err := scanTasks(rows)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	json.NewEncoder(w).Encode(tasks)
@@ -297,7 +305,7 @@ func (h *EmployeeHandler) GetEmployeeProjects(w http.ResponseWriter, r *http.Req
         JOIN employee_projects ep ON p.id = ep.project_id
         WHERE ep.employee_id = $1`
 
-	rows, err := h.db.Query(context.Background(), query, employeeId)
+	rows, err := h.db.Query(r.Context(), query, employeeId)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -324,6 +332,9 @@ func (h *EmployeeHandler) GetEmployeeProjects(w http.ResponseWriter, r *http.Req
 	json.NewEncoder(w).Encode(projects)
 }
 
+// AssignEmployeeToProject adds an employee to a project. Assigning an
+// existing member leaves the membership untouched and returns it with 200;
+// UpdateProjectMembership changes it.
 func (h *EmployeeHandler) AssignEmployeeToProject(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	employeeId, err := strconv.Atoi(vars["employeeId"])
@@ -338,18 +349,158 @@ func (h *EmployeeHandler) AssignEmployeeToProject(w http.ResponseWriter, r *http
 		return
 	}
 
+	if !authorize(w, r, h.policy, auth.ManageMembers, projectId) {
+		return
+	}
+
+	membership, ok := decodeMembership(w, r)
+	if !ok {
+		return
+	}
+
 	query := `
-        INSERT INTO employee_projects (employee_id, project_id)
-        VALUES ($1, $2)
-        ON CONFLICT (employee_id, project_id) DO NOTHING`
+        INSERT INTO employee_projects (employee_id, project_id, role, allocation_percent, start_date, end_date)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        ON CONFLICT (employee_id, project_id) DO NOTHING
+        RETURNING employee_id, project_id, role, allocation_percent, start_date, end_date, created_at`
+
+	scan := func(row pgx.Row) error {
+		return row.Scan(
+			&membership.EmployeeID,
+			&membership.ProjectID,
+			&membership.Role,
+			&membership.AllocationPercent,
+			&membership.StartDate,
+			&membership.EndDate,
+			&membership.CreatedAt,
+		)
+	}
 
-	_, err = h.db.Exec(context.Background(), query, employeeId, projectId)
+	change := h.audit.Begin(r.Context(), audit.Membership, employeeId, projectId)
+	err = scan(h.db.QueryRow(r.Context(), query,
+		employeeId,
+		projectId,
+		membership.Role,
+		membership.AllocationPercent,
+		membership.StartDate,
+		membership.EndDate,
+	))
+	if err == pgx.ErrNoRows {
+		// An existing membership is left untouched; return whatever is stored
+		query = `
+        SELECT employee_id, project_id, role, allocation_percent, start_date, end_date, created_at
+        FROM employee_projects
+        WHERE employee_id = $1 AND project_id = $2`
+
+		if err := scan(h.db.QueryRow(r.Context(), query, employeeId, projectId)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(membership)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	change.Record(r.Context(), audit.Assign)
+
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(membership)
+}
+
+// UpdateProjectMembership changes the role, allocation and dates of an
+// employee's membership in a project
+func (h *EmployeeHandler) UpdateProjectMembership(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	employeeId, err := strconv.Atoi(vars["employeeId"])
+	if err != nil {
+		http.Error(w, "Invalid employee ID", http.StatusBadRequest)
+		return
+	}
+
+	projectId, err := strconv.Atoi(vars["projectId"])
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	if !authorize(w, r, h.policy, auth.ManageMembers, projectId) {
+		return
+	}
+
+	membership, ok := decodeMembership(w, r)
+	if !ok {
+		return
+	}
+
+	query := `
+        UPDATE employee_projects
+        SET role = $1, allocation_percent = $2, start_date = $3, end_date = $4
+        WHERE employee_id = $5 AND project_id = $6
+        RETURNING employee_id, project_id, role, allocation_percent, start_date, end_date, created_at`
+
+	change := h.audit.Begin(r.Context(), audit.Membership, employeeId, projectId)
+	err = h.db.QueryRow(r.Context(), query,
+		membership.Role,
+		membership.AllocationPercent,
+		membership.StartDate,
+		membership.EndDate,
+		employeeId,
+		projectId,
+	).Scan(
+		&membership.EmployeeID,
+		&membership.ProjectID,
+		&membership.Role,
+		&membership.AllocationPercent,
+		&membership.StartDate,
+		&membership.EndDate,
+		&membership.CreatedAt,
+	)
+
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Assignment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	change.Record(r.Context(), audit.Update)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(membership)
+}
+
+// decodeMembership reads membership details from the request body, applying
+// defaults for omitted fields. An empty body yields a full-time contributor.
+func decodeMembership(w http.ResponseWriter, r *http.Request) (models.ProjectMembership, bool) {
+	membership := models.ProjectMembership{
+		Role:              models.MembershipContributor,
+		AllocationPercent: 100,
+	}
+	if err := json.NewDecoder(r.Body).Decode(&membership); err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return membership, false
+	}
+
+	if !membership.Role.Valid() {
+		http.Error(w, "Role must be one of LEAD, CONTRIBUTOR, REVIEWER, OBSERVER", http.StatusBadRequest)
+		return membership, false
+	}
+	if membership.AllocationPercent < 0 || membership.AllocationPercent > 100 {
+		http.Error(w, "Allocation must be between 0 and 100 percent", http.StatusBadRequest)
+		return membership, false
+	}
+	if membership.StartDate != nil && membership.EndDate != nil && membership.EndDate.Before(membership.StartDate.Time) {
+		http.Error(w, "End date must not be before start date", http.StatusBadRequest)
+		return membership, false
+	}
+	return membership, true
 }
 
 func (h *EmployeeHandler) RemoveEmployeeFromProject(w http.ResponseWriter, r *http.Request) {
@@ -366,11 +517,16 @@ func (h *EmployeeHandler) RemoveEmployeeFromProject(w http.ResponseWriter, r *ht
 		return
 	}
 
+	if !authorize(w, r, h.policy, auth.ManageMembers, projectId) {
+		return
+	}
+
 	query := `
         DELETE FROM employee_projects
         WHERE employee_id = $1 AND project_id = $2`
 
-	result, err := h.db.Exec(context.Background(), query, employeeId, projectId)
+	change := h.audit.Begin(r.Context(), audit.Membership, employeeId, projectId)
+	result, err := h.db.Exec(r.Context(), query, employeeId, projectId)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -381,6 +537,8 @@ func (h *EmployeeHandler) RemoveEmployeeFromProject(w http.ResponseWriter, r *ht
 		return
 	}
 
+	change.Record(r.Context(), audit.Unassign)
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -393,12 +551,13 @@ func (h *EmployeeHandler) GetEmployeesByProject(w http.ResponseWriter, r *http.R
 	}
 
 	query := `
-        SELECT e.id, e.name, e.email, e.role, e.created_at
+        SELECT e.id, e.name, e.email, e.role, e.created_at,
+               ep.role, ep.allocation_percent, ep.start_date, ep.end_date, ep.created_at
         FROM employees e
         JOIN employee_projects ep ON e.id = ep.employee_id
         WHERE ep.project_id = $1`
 
-	rows, err := h.db.Query(context.Background(), query, projectId)
+	rows, err := h.db.Query(r.Context(), query, projectId)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -408,17 +567,25 @@ func (h *EmployeeHandler) GetEmployeesByProject(w http.ResponseWriter, r *http.R
 	var employees []models.Employee
 	for rows.Next() {
 		var emp models.Employee
+		membership := models.ProjectMembership{ProjectID: projectId}
 		err := rows.Scan(
 			&emp.ID,
 			&emp.Name,
 			&emp.Email,
 			&emp.Role,
 			&emp.CreatedAt,
+			&membership.Role,
+			&membership.AllocationPercent,
+			&membership.StartDate,
+			&membership.EndDate,
+			&membership.CreatedAt,
 		)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		membership.EmployeeID = emp.ID
+		emp.Membership = &membership
 		employees = append(employees, emp)
 	}
 
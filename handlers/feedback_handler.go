@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"nstorm.com/main-backend/auth"
+	"nstorm.com/main-backend/models"
+)
+
+type FeedbackHandler struct {
+	db *pgxpool.Pool
+}
+
+func NewFeedbackHandler(db *pgxpool.Pool) *FeedbackHandler {
+	return &FeedbackHandler{db: db}
+}
+
+// CreateTaskFeedback rates a task produced by the planner
+func (h *FeedbackHandler) CreateTaskFeedback(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	taskID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return
+	}
+
+	var feedback models.TaskFeedback
+	if err := json.NewDecoder(r.Body).Decode(&feedback); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !feedback.Rating.Valid() {
+		http.Error(w, "Rating must be one of USEFUL, WRONG_ASSIGNEE, DUPLICATE, IRRELEVANT", http.StatusBadRequest)
+		return
+	}
+
+	// Feedback is attributed to the caller rather than to a body field
+	if principal := auth.FromContext(r.Context()); !principal.Service {
+		feedback.EmployeeID = &principal.EmployeeID
+	}
+
+	ctx := r.Context()
+	var runID *int
+	err = h.db.QueryRow(ctx, `SELECT generation_run_id FROM tasks WHERE id = $1`, taskID).Scan(&runID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if runID == nil {
+		http.Error(w, "Task was not generated by the planner", http.StatusBadRequest)
+		return
+	}
+
+	query := `
+        INSERT INTO task_feedback (task_id, generation_run_id, employee_id, rating, comment)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING id, task_id, generation_run_id, created_at`
+
+	err = h.db.QueryRow(ctx, query,
+		taskID,
+		*runID,
+		feedback.EmployeeID,
+		feedback.Rating,
+		feedback.Comment,
+	).Scan(&feedback.ID, &feedback.TaskID, &feedback.GenerationRunID, &feedback.CreatedAt)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(feedback)
+}
+
+// GetTaskFeedback lists the feedback left on a task
+func (h *FeedbackHandler) GetTaskFeedback(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	taskID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return
+	}
+
+	query := `
+        SELECT id, task_id, COALESCE(generation_run_id, 0), employee_id, rating, COALESCE(comment, ''), created_at
+        FROM task_feedback
+        WHERE task_id = $1
+        ORDER BY created_at`
+
+	rows, err := h.db.Query(r.Context(), query, taskID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var feedback []models.TaskFeedback
+	for rows.Next() {
+		var f models.TaskFeedback
+		err := rows.Scan(
+			&f.ID,
+			&f.TaskID,
+			&f.GenerationRunID,
+			&f.EmployeeID,
+			&f.Rating,
+			&f.Comment,
+			&f.CreatedAt,
+		)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		feedback = append(feedback, f)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(feedback)
+}
+
+// GetEvaluationReport aggregates how generated tasks fared, grouped by planner
+// backend and prompt version. Only the latest rating of each task counts.
+// Optional ?from= and ?to= (YYYY-MM-DD or RFC 3339) restrict the runs
+// considered, and ?project_id= restricts them to one project.
+func (h *FeedbackHandler) GetEvaluationReport(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	from, err := parseTimeParam(params.Get("from"))
+	if err != nil {
+		http.Error(w, "Invalid from date", http.StatusBadRequest)
+		return
+	}
+	to, err := parseTimeParam(params.Get("to"))
+	if err != nil {
+		http.Error(w, "Invalid to date", http.StatusBadRequest)
+		return
+	}
+	var projectID *int
+	if value := params.Get("project_id"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "Invalid project ID", http.StatusBadRequest)
+			return
+		}
+		projectID = &id
+	}
+
+	query := `
+        WITH generated AS (
+            SELECT r.planner_backend, r.prompt_version, r.id AS run_id, t.id AS task_id,
+                   t.assigned_to IS DISTINCT FROM t.generated_assigned_to AS reassigned,
+                   t.status = 'DONE' AS completed
+            FROM generation_runs r
+            LEFT JOIN tasks t ON t.generation_run_id = r.id
+            WHERE r.status = 'SUCCEEDED'
+              AND ($1::timestamptz IS NULL OR r.created_at >= $1)
+              AND ($2::timestamptz IS NULL OR r.created_at < $2)
+              AND ($3::integer IS NULL OR r.project_id = $3)
+        ),
+        latest AS (
+            SELECT DISTINCT ON (task_id) task_id, rating
+            FROM task_feedback
+            ORDER BY task_id, created_at DESC
+        )
+        SELECT g.planner_backend, g.prompt_version,
+               COUNT(DISTINCT g.run_id),
+               COUNT(g.task_id),
+               COUNT(l.task_id),
+               COUNT(*) FILTER (WHERE l.rating = 'USEFUL'),
+               COUNT(*) FILTER (WHERE l.rating = 'WRONG_ASSIGNEE'),
+               COUNT(*) FILTER (WHERE l.rating = 'DUPLICATE'),
+               COUNT(*) FILTER (WHERE l.rating = 'IRRELEVANT'),
+               COUNT(*) FILTER (WHERE g.reassigned),
+               COUNT(*) FILTER (WHERE g.completed)
+        FROM generated g
+        LEFT JOIN latest l ON l.task_id = g.task_id
+        GROUP BY g.planner_backend, g.prompt_version
+        ORDER BY g.planner_backend, g.prompt_version`
+
+	rows, err := h.db.Query(r.Context(), query, from, to, projectID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	report := []models.PlannerEvaluation{}
+	for rows.Next() {
+		var e models.PlannerEvaluation
+		var useful, wrongAssignee, duplicate, irrelevant, reassigned, completed int
+		err := rows.Scan(
+			&e.PlannerBackend,
+			&e.PromptVersion,
+			&e.Runs,
+			&e.GeneratedTasks,
+			&e.RatedTasks,
+			&useful,
+			&wrongAssignee,
+			&duplicate,
+			&irrelevant,
+			&reassigned,
+			&completed,
+		)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		e.Ratings = map[models.FeedbackRating]int{
+			models.RatingUseful:        useful,
+			models.RatingWrongAssignee: wrongAssignee,
+			models.RatingDuplicate:     duplicate,
+			models.RatingIrrelevant:    irrelevant,
+		}
+		e.AcceptanceRate = ratio(useful, e.RatedTasks)
+		e.ReassignmentRate = ratio(reassigned, e.GeneratedTasks)
+		e.CompletionRate = ratio(completed, e.GeneratedTasks)
+		report = append(report, e)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+func ratio(part, whole int) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return float64(part) / float64(whole)
+}
+
+// parseTimeParam accepts an empty string, a date or an RFC 3339 timestamp.
+func parseTimeParam(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
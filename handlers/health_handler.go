@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"nstorm.com/main-backend/planner"
+)
+
+// checkTimeout bounds each dependency check so a hung dependency cannot hold
+// a probe open longer than the orchestrator waits for it.
+const checkTimeout = 2 * time.Second
+
+// requiredColumns lists a column added by each schema change in init.sql.
+// Readiness fails until all of them exist, so an instance never serves
+// traffic against a database that has not been migrated. Append the newest
+// column whenever init.sql changes.
+var requiredColumns = []string{
+	"employees.password_hash",
+	"employee_projects.allocation_percent",
+	"tasks.generation_run_id",
+	"generation_runs.redactions",
+	"project_budgets.monthly_token_limit",
+	"task_feedback.rating",
+	"api_keys.key_hash",
+	"audit_events.diff",
+	"tasks.actual_hours",
+	"task_dependencies.depends_on_id",
+	"tasks.parent_task_id",
+	"task_comment_mentions.employee_id",
+	"project_labels.label_id",
+	"attachments.sha256",
+	"time_entries.ended_at",
+	"tasks.sprint_id",
+	"tasks.milestone_id",
+	"tasks.status_before_blocked",
+	"sprints.closed_completed_story_points",
+}
+
+type HealthHandler struct {
+	db           *pgxpool.Pool
+	planner      *planner.Client
+	checkPlanner bool
+	version      string
+	startedAt    time.Time
+}
+
+// NewHealthHandler creates the probe handlers. When checkPlanner is set the
+// planner service must be reachable for the backend to report ready.
+func NewHealthHandler(db *pgxpool.Pool, planner *planner.Client, checkPlanner bool, version string) *HealthHandler {
+	return &HealthHandler{db: db, planner: planner, checkPlanner: checkPlanner, version: version, startedAt: time.Now()}
+}
+
+type CheckResult struct {
+	Status    string  `json:"status"`
+	Required  bool    `json:"required"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type BuildInfo struct {
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version"`
+	Revision  string `json:"revision,omitempty"`
+	BuildTime string `json:"build_time,omitempty"`
+	Modified  bool   `json:"modified"`
+}
+
+type StatusReport struct {
+	Status        string                 `json:"status"`
+	Build         BuildInfo              `json:"build"`
+	StartedAt     time.Time              `json:"started_at"`
+	UptimeSeconds int64                  `json:"uptime_seconds"`
+	Checks        map[string]CheckResult `json:"checks"`
+}
+
+// Healthz reports that the process is up. It checks no dependencies so a
+// database outage does not get the container restarted.
+func (h *HealthHandler) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// Readyz reports whether the backend can serve traffic: the database answers
+// and is migrated, and the planner is reachable when that check is enabled.
+func (h *HealthHandler) Readyz(w http.ResponseWriter, r *http.Request) {
+	checks := h.runChecks(r.Context())
+	status := overallStatus(checks)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]any{"status": status, "checks": checks})
+}
+
+// Status summarises dependency health along with version and build details.
+// Optional dependencies are checked too, but only degrade the status.
+func (h *HealthHandler) Status(w http.ResponseWriter, r *http.Request) {
+	checks := h.runChecks(r.Context())
+	if _, ok := checks["planner"]; !ok {
+		checks["planner"] = h.check(r.Context(), false, h.planner.Ping)
+	}
+
+	report := StatusReport{
+		Status:        overallStatus(checks),
+		Build:         h.buildInfo(),
+		StartedAt:     h.startedAt,
+		UptimeSeconds: int64(time.Since(h.startedAt).Seconds()),
+		Checks:        checks,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(report)
+}
+
+// runChecks runs the readiness checks concurrently.
+func (h *HealthHandler) runChecks(ctx context.Context) map[string]CheckResult {
+	checks := map[string]func(context.Context) error{
+		"database":   h.db.Ping,
+		"migrations": h.checkMigrations,
+	}
+	if h.checkPlanner {
+		checks["planner"] = h.planner.Ping
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]CheckResult, len(checks))
+	for name, fn := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := h.check(ctx, true, fn)
+			mu.Lock()
+			results[name] = result
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func (h *HealthHandler) check(ctx context.Context, required bool, fn func(context.Context) error) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := fn(ctx)
+	result := CheckResult{
+		Status:    "ok",
+		Required:  required,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = "failing"
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func (h *HealthHandler) checkMigrations(ctx context.Context) error {
+	query := `
+        SELECT table_name || '.' || column_name
+        FROM information_schema.columns
+        WHERE table_schema = current_schema()
+          AND table_name || '.' || column_name = ANY($1)`
+
+	rows, err := h.db.Query(ctx, query, requiredColumns)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	present := make(map[string]bool)
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return err
+		}
+		present[column] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var missing []string
+	for _, column := range requiredColumns {
+		if !present[column] {
+			missing = append(missing, column)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("schema is missing %s; apply init.sql", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func (h *HealthHandler) buildInfo() BuildInfo {
+	info := BuildInfo{Version: h.version, GoVersion: runtime.Version()}
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.Revision = setting.Value
+		case "vcs.time":
+			info.BuildTime = setting.Value
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+	return info
+}
+
+// overallStatus is "failing" when a required check fails and "degraded" when
+// only optional ones do.
+func overallStatus(checks map[string]CheckResult) string {
+	status := "ok"
+	for _, check := range checks {
+		if check.Status == "ok" {
+			continue
+		}
+		if check.Required {
+			return "failing"
+		}
+		status = "degraded"
+	}
+	return status
+}
@@ -0,0 +1,475 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"nstorm.com/main-backend/audit"
+	"nstorm.com/main-backend/auth"
+	"nstorm.com/main-backend/models"
+)
+
+const defaultLabelColor = "#808080"
+
+var colorPattern = regexp.MustCompile(`^#[0-9a-f]{6}$`)
+
+type LabelHandler struct {
+	db     *pgxpool.Pool
+	policy *auth.Policy
+	audit  *audit.Recorder
+}
+
+func NewLabelHandler(db *pgxpool.Pool, policy *auth.Policy, audit *audit.Recorder) *LabelHandler {
+	return &LabelHandler{db: db, policy: policy, audit: audit}
+}
+
+const labelColumns = `l.id, l.project_id, l.name, l.color, COALESCE(l.description, ''), l.created_at`
+
+func scanLabel(row pgx.Row, label *models.Label) error {
+	return row.Scan(&label.ID, &label.ProjectID, &label.Name, &label.Color, &label.Description, &label.CreatedAt)
+}
+
+func scanLabels(rows pgx.Rows) ([]models.Label, error) {
+	defer rows.Close()
+
+	labels := []models.Label{}
+	for rows.Next() {
+		var label models.Label
+		if err := scanLabel(rows, &label); err != nil {
+			return nil, err
+		}
+		labels = append(labels, label)
+	}
+	return labels, rows.Err()
+}
+
+// GetLabels lists labels by name. With ?project_id= only the labels usable in
+// that project are listed: the global ones and the project's own.
+func (h *LabelHandler) GetLabels(w http.ResponseWriter, r *http.Request) {
+	var projectID *int
+	if value := r.URL.Query().Get("project_id"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "Invalid project ID", http.StatusBadRequest)
+			return
+		}
+		projectID = &id
+	}
+
+	query := `
+        SELECT ` + labelColumns + `
+        FROM labels l
+        WHERE $1::integer IS NULL OR l.project_id IS NULL OR l.project_id = $1
+        ORDER BY lower(l.name), l.project_id NULLS FIRST`
+
+	rows, err := h.db.Query(r.Context(), query, projectID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	labels, err := scanLabels(rows)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(labels)
+}
+
+// CreateLabel creates a project label, or a global one when project_id is
+// not set. Only project managers may create global labels.
+func (h *LabelHandler) CreateLabel(w http.ResponseWriter, r *http.Request) {
+	var label models.Label
+	if err := json.NewDecoder(r.Body).Decode(&label); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateLabel(&label); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !authorize(w, r, h.policy, auth.ManageTasks, labelScope(&label)) {
+		return
+	}
+
+	query := `
+        INSERT INTO labels (project_id, name, color, description)
+        VALUES ($1, $2, $3, NULLIF($4, ''))
+        RETURNING id, created_at`
+
+	err := h.db.QueryRow(r.Context(), query, label.ProjectID, label.Name, label.Color, label.Description).Scan(&label.ID, &label.CreatedAt)
+	if err != nil {
+		writeLabelError(w, err)
+		return
+	}
+
+	h.audit.Created(audit.Label, label.ID).Record(r.Context(), audit.Create)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(label)
+}
+
+// UpdateLabel renames or recolours a label. Its scope cannot change.
+func (h *LabelHandler) UpdateLabel(w http.ResponseWriter, r *http.Request) {
+	labelID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid label ID", http.StatusBadRequest)
+		return
+	}
+
+	var label models.Label
+	if err := json.NewDecoder(r.Body).Decode(&label); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	current, err := h.loadLabel(r.Context(), labelID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Label not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	label.ProjectID = current.ProjectID
+	if err := validateLabel(&label); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !authorize(w, r, h.policy, auth.ManageTasks, labelScope(current)) {
+		return
+	}
+
+	query := `
+        UPDATE labels l
+        SET name = $1, color = $2, description = NULLIF($3, '')
+        WHERE l.id = $4
+        RETURNING ` + labelColumns
+
+	ctx := r.Context()
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	change := h.audit.In(tx).Begin(ctx, audit.Label, labelID)
+	err = scanLabel(tx.QueryRow(ctx, query, label.Name, label.Color, label.Description, labelID), &label)
+	if err != nil {
+		writeLabelError(w, err)
+		return
+	}
+	change.Record(ctx, audit.Update)
+
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(label)
+}
+
+// DeleteLabel deletes a label and removes it from every task and project.
+func (h *LabelHandler) DeleteLabel(w http.ResponseWriter, r *http.Request) {
+	labelID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid label ID", http.StatusBadRequest)
+		return
+	}
+
+	current, err := h.loadLabel(r.Context(), labelID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Label not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !authorize(w, r, h.policy, auth.ManageTasks, labelScope(current)) {
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	change := h.audit.In(tx).Begin(ctx, audit.Label, labelID)
+	if _, err := tx.Exec(ctx, `DELETE FROM labels WHERE id = $1`, labelID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	change.Record(ctx, audit.Delete)
+
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetTaskLabels lists the labels of a task.
+func (h *LabelHandler) GetTaskLabels(w http.ResponseWriter, r *http.Request) {
+	h.listAttached(w, r, `SELECT project_id FROM tasks WHERE id = $1`, "Task not found", `
+        SELECT `+labelColumns+`
+        FROM labels l
+        JOIN task_labels tl ON tl.label_id = l.id
+        WHERE tl.task_id = $1
+        ORDER BY lower(l.name)`)
+}
+
+// GetProjectLabels lists the labels attached to a project itself, not the
+// labels defined for its tasks.
+func (h *LabelHandler) GetProjectLabels(w http.ResponseWriter, r *http.Request) {
+	h.listAttached(w, r, `SELECT id FROM projects WHERE id = $1`, "Project not found", `
+        SELECT `+labelColumns+`
+        FROM labels l
+        JOIN project_labels pl ON pl.label_id = l.id
+        WHERE pl.project_id = $1
+        ORDER BY lower(l.name)`)
+}
+
+// AddTaskLabel attaches a label to a task. The label must be global or
+// belong to the task's project.
+func (h *LabelHandler) AddTaskLabel(w http.ResponseWriter, r *http.Request) {
+	h.attach(w, r, attachment{
+		ownerQuery:  `SELECT project_id FROM tasks WHERE id = $1`,
+		notFound:    "Task not found",
+		action:      auth.ManageTasks,
+		entity:      audit.TaskLabel,
+		insertQuery: `INSERT INTO task_labels (task_id, label_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+	})
+}
+
+func (h *LabelHandler) RemoveTaskLabel(w http.ResponseWriter, r *http.Request) {
+	h.detach(w, r, attachment{
+		ownerQuery:  `SELECT project_id FROM tasks WHERE id = $1`,
+		notFound:    "Task not found",
+		action:      auth.ManageTasks,
+		entity:      audit.TaskLabel,
+		deleteQuery: `DELETE FROM task_labels WHERE task_id = $1 AND label_id = $2`,
+	})
+}
+
+// AddProjectLabel attaches a label to a project. The label must be global
+// or belong to the project.
+func (h *LabelHandler) AddProjectLabel(w http.ResponseWriter, r *http.Request) {
+	h.attach(w, r, attachment{
+		ownerQuery:  `SELECT id FROM projects WHERE id = $1`,
+		notFound:    "Project not found",
+		action:      auth.UpdateProject,
+		entity:      audit.ProjectLabel,
+		insertQuery: `INSERT INTO project_labels (project_id, label_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+	})
+}
+
+func (h *LabelHandler) RemoveProjectLabel(w http.ResponseWriter, r *http.Request) {
+	h.detach(w, r, attachment{
+		ownerQuery:  `SELECT id FROM projects WHERE id = $1`,
+		notFound:    "Project not found",
+		action:      auth.UpdateProject,
+		entity:      audit.ProjectLabel,
+		deleteQuery: `DELETE FROM project_labels WHERE project_id = $1 AND label_id = $2`,
+	})
+}
+
+// attachment describes what labels are attached to: a task or a project.
+// ownerQuery selects the project the owner belongs to.
+type attachment struct {
+	ownerQuery  string
+	notFound    string
+	action      auth.Action
+	entity      audit.Entity
+	insertQuery string
+	deleteQuery string
+}
+
+func (h *LabelHandler) attach(w http.ResponseWriter, r *http.Request, a attachment) {
+	ownerID, labelID, ok := labelParams(w, r)
+	if !ok {
+		return
+	}
+	projectID, ok := h.ownerProject(w, r, a, ownerID)
+	if !ok {
+		return
+	}
+
+	label, err := h.loadLabel(r.Context(), labelID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Label not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if label.ProjectID != nil && *label.ProjectID != projectID {
+		http.Error(w, "Label belongs to another project", http.StatusBadRequest)
+		return
+	}
+	if !authorize(w, r, h.policy, a.action, projectID) {
+		return
+	}
+
+	change := h.audit.Begin(r.Context(), a.entity, ownerID, labelID)
+	if _, err := h.db.Exec(r.Context(), a.insertQuery, ownerID, labelID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	change.Record(r.Context(), audit.Create)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(label)
+}
+
+func (h *LabelHandler) detach(w http.ResponseWriter, r *http.Request, a attachment) {
+	ownerID, labelID, ok := labelParams(w, r)
+	if !ok {
+		return
+	}
+	projectID, ok := h.ownerProject(w, r, a, ownerID)
+	if !ok {
+		return
+	}
+	if !authorize(w, r, h.policy, a.action, projectID) {
+		return
+	}
+
+	change := h.audit.Begin(r.Context(), a.entity, ownerID, labelID)
+	result, err := h.db.Exec(r.Context(), a.deleteQuery, ownerID, labelID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected() == 0 {
+		http.Error(w, "Label is not attached", http.StatusNotFound)
+		return
+	}
+	change.Record(r.Context(), audit.Delete)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *LabelHandler) listAttached(w http.ResponseWriter, r *http.Request, ownerQuery, notFound, query string) {
+	ownerID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	if _, ok := h.ownerProject(w, r, attachment{ownerQuery: ownerQuery, notFound: notFound}, ownerID); !ok {
+		return
+	}
+
+	rows, err := h.db.Query(r.Context(), query, ownerID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	labels, err := scanLabels(rows)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(labels)
+}
+
+func (h *LabelHandler) ownerProject(w http.ResponseWriter, r *http.Request, a attachment, ownerID int) (int, bool) {
+	var projectID int
+	err := h.db.QueryRow(r.Context(), a.ownerQuery, ownerID).Scan(&projectID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, a.notFound, http.StatusNotFound)
+		return 0, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return 0, false
+	}
+	return projectID, true
+}
+
+func (h *LabelHandler) loadLabel(ctx context.Context, labelID int) (*models.Label, error) {
+	var label models.Label
+	err := scanLabel(h.db.QueryRow(ctx, `SELECT `+labelColumns+` FROM labels l WHERE l.id = $1`, labelID), &label)
+	if err != nil {
+		return nil, err
+	}
+	return &label, nil
+}
+
+// validateLabel trims the name and normalises the colour to lower-case
+// "#rrggbb", defaulting to grey.
+func validateLabel(label *models.Label) error {
+	if label.ProjectID != nil && *label.ProjectID == 0 {
+		label.ProjectID = nil
+	}
+	label.Name = strings.TrimSpace(label.Name)
+	if label.Name == "" || len(label.Name) > 50 || strings.Contains(label.Name, ",") {
+		return fmt.Errorf("Label name is required, at most 50 characters and without commas")
+	}
+	label.Color = strings.ToLower(strings.TrimSpace(label.Color))
+	if label.Color == "" {
+		label.Color = defaultLabelColor
+	}
+	if !colorPattern.MatchString(label.Color) {
+		return fmt.Errorf("color must be a hex colour like #1f77b4")
+	}
+	return nil
+}
+
+// labelScope is the project a label belongs to, 0 for global labels.
+func labelScope(label *models.Label) int {
+	if label.ProjectID == nil {
+		return 0
+	}
+	return *label.ProjectID
+}
+
+func writeLabelError(w http.ResponseWriter, err error) {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		http.Error(w, "A label with this name already exists", http.StatusConflict)
+		return
+	}
+	if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+		http.Error(w, "Project not found", http.StatusBadRequest)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+func labelParams(w http.ResponseWriter, r *http.Request) (ownerID, labelID int, ok bool) {
+	vars := mux.Vars(r)
+	ownerID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	labelID, err = strconv.Atoi(vars["labelId"])
+	if err != nil {
+		http.Error(w, "Invalid label ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	return ownerID, labelID, true
+}
@@ -0,0 +1,594 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"nstorm.com/main-backend/audit"
+	"nstorm.com/main-backend/auth"
+	"nstorm.com/main-backend/models"
+	"nstorm.com/main-backend/schedule"
+)
+
+const (
+	maxMilestoneName        = 100
+	maxMilestoneDescription = 5000
+)
+
+type MilestoneHandler struct {
+	db      *pgxpool.Pool
+	policy  *auth.Policy
+	audit   *audit.Recorder
+	options schedule.Options
+}
+
+// NewMilestoneHandler uses hoursPerDay and hoursPerStoryPoint to compare the
+// open work of a milestone with the time left until its target date.
+func NewMilestoneHandler(db *pgxpool.Pool, policy *auth.Policy, audit *audit.Recorder, hoursPerDay, hoursPerStoryPoint float64) *MilestoneHandler {
+	return &MilestoneHandler{db: db, policy: policy, audit: audit, options: schedule.Options{
+		HoursPerDay:        hoursPerDay,
+		HoursPerStoryPoint: hoursPerStoryPoint,
+		DefaultDays:        1,
+	}}
+}
+
+// milestoneColumns is the select list read by scanMilestone, for queries
+// that alias milestones as m.
+const milestoneColumns = `m.id, m.project_id, m.name, COALESCE(m.description, ''), m.target_date, m.created_at`
+
+func scanMilestone(row pgx.Row, milestone *models.Milestone) error {
+	return row.Scan(
+		&milestone.ID,
+		&milestone.ProjectID,
+		&milestone.Name,
+		&milestone.Description,
+		&milestone.TargetDate,
+		&milestone.CreatedAt,
+	)
+}
+
+// GetProjectMilestones lists the milestones of a project by target date,
+// with their progress.
+func (h *MilestoneHandler) GetProjectMilestones(w http.ResponseWriter, r *http.Request) {
+	projectID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	var exists bool
+	if err := h.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM projects WHERE id = $1)`, projectID).Scan(&exists); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	query := `
+        SELECT ` + milestoneColumns + `
+        FROM milestones m
+        WHERE m.project_id = $1
+        ORDER BY m.target_date, m.id`
+
+	rows, err := h.db.Query(ctx, query, projectID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	milestones := []models.Milestone{}
+	for rows.Next() {
+		var milestone models.Milestone
+		if err := scanMilestone(rows, &milestone); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		milestones = append(milestones, milestone)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.withProgress(ctx, milestones); err != nil {
+		writeProgressError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(milestones)
+}
+
+func (h *MilestoneHandler) CreateMilestone(w http.ResponseWriter, r *http.Request) {
+	projectID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	var milestone models.Milestone
+	if err := json.NewDecoder(r.Body).Decode(&milestone); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateMilestone(&milestone); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	var exists bool
+	if err := h.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM projects WHERE id = $1)`, projectID).Scan(&exists); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	if !authorize(w, r, h.policy, auth.UpdateProject, projectID) {
+		return
+	}
+
+	query := `
+        INSERT INTO milestones AS m (project_id, name, description, target_date)
+        VALUES ($1, $2, NULLIF($3, ''), $4)
+        RETURNING ` + milestoneColumns
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	err = scanMilestone(tx.QueryRow(ctx, query, projectID, milestone.Name, milestone.Description, milestone.TargetDate), &milestone)
+	if err != nil {
+		writeMilestoneError(w, err)
+		return
+	}
+	h.audit.In(tx).Created(audit.Milestone, milestone.ID).Record(ctx, audit.Create)
+
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	milestones := []models.Milestone{milestone}
+	if err := h.withProgress(ctx, milestones); err != nil {
+		writeProgressError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(milestones[0])
+}
+
+func (h *MilestoneHandler) GetMilestone(w http.ResponseWriter, r *http.Request) {
+	milestoneID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid milestone ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	milestone, err := h.loadMilestone(ctx, milestoneID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Milestone not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	milestones := []models.Milestone{*milestone}
+	if err := h.withProgress(ctx, milestones); err != nil {
+		writeProgressError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(milestones[0])
+}
+
+// UpdateMilestone changes the name, description and target date of a
+// milestone.
+func (h *MilestoneHandler) UpdateMilestone(w http.ResponseWriter, r *http.Request) {
+	milestoneID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid milestone ID", http.StatusBadRequest)
+		return
+	}
+
+	var milestone models.Milestone
+	if err := json.NewDecoder(r.Body).Decode(&milestone); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateMilestone(&milestone); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	current, err := h.loadMilestone(ctx, milestoneID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Milestone not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !authorize(w, r, h.policy, auth.UpdateProject, current.ProjectID) {
+		return
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	change := h.audit.In(tx).Begin(ctx, audit.Milestone, milestoneID)
+	query := `
+        UPDATE milestones m
+        SET name = $1, description = NULLIF($2, ''), target_date = $3
+        WHERE m.id = $4
+        RETURNING ` + milestoneColumns
+
+	err = scanMilestone(tx.QueryRow(ctx, query, milestone.Name, milestone.Description, milestone.TargetDate, milestoneID), &milestone)
+	if err != nil {
+		writeMilestoneError(w, err)
+		return
+	}
+	change.Record(ctx, audit.Update)
+
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	milestones := []models.Milestone{milestone}
+	if err := h.withProgress(ctx, milestones); err != nil {
+		writeProgressError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(milestones[0])
+}
+
+// DeleteMilestone deletes a milestone; its tasks are kept and unlinked.
+func (h *MilestoneHandler) DeleteMilestone(w http.ResponseWriter, r *http.Request) {
+	milestoneID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid milestone ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	milestone, err := h.loadMilestone(ctx, milestoneID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Milestone not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !authorize(w, r, h.policy, auth.UpdateProject, milestone.ProjectID) {
+		return
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	change := h.audit.In(tx).Begin(ctx, audit.Milestone, milestoneID)
+	if _, err := tx.Exec(ctx, `DELETE FROM milestones WHERE id = $1`, milestoneID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	change.Record(ctx, audit.Delete)
+
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetMilestoneTasks lists the tasks of a milestone. It accepts the filters
+// of the other task listings.
+func (h *MilestoneHandler) GetMilestoneTasks(w http.ResponseWriter, r *http.Request) {
+	milestoneID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid milestone ID", http.StatusBadRequest)
+		return
+	}
+	filter, err := parseTaskFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.where("t.milestone_id = $%d", milestoneID)
+
+	ctx := r.Context()
+	var exists bool
+	if err := h.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM milestones WHERE id = $1)`, milestoneID).Scan(&exists); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Milestone not found", http.StatusNotFound)
+		return
+	}
+
+	query := `
+        SELECT ` + taskColumns + `
+        FROM tasks t` + filter.clause() + `
+        ORDER BY t.id`
+
+	rows, err := h.db.Query(ctx, query, filter.args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tasks, err := scanTasks(rows)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if tasks == nil {
+		tasks = []models.Task{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tasks)
+}
+
+// AddMilestoneTask links a task of the milestone's project to the
+// milestone, replacing any other milestone it was linked to.
+func (h *MilestoneHandler) AddMilestoneTask(w http.ResponseWriter, r *http.Request) {
+	milestoneID, taskID, ok := milestoneTaskParams(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	milestone, ok := h.milestoneForTasks(w, r, milestoneID)
+	if !ok {
+		return
+	}
+
+	var projectID int
+	err := h.db.QueryRow(ctx, `SELECT project_id FROM tasks WHERE id = $1`, taskID).Scan(&projectID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := checkMilestone(ctx, h.db, projectID, &milestone.ID); err != nil {
+		writeTaskRuleError(w, err)
+		return
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	change := h.audit.In(tx).Begin(ctx, audit.Task, taskID)
+	query := `
+        UPDATE tasks t
+        SET milestone_id = $1
+        WHERE t.id = $2
+        RETURNING ` + taskColumns
+
+	var task models.Task
+	if err := scanTask(tx.QueryRow(ctx, query, milestoneID, taskID), &task); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	change.Record(ctx, audit.Update)
+
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(task)
+}
+
+// RemoveMilestoneTask unlinks a task from a milestone.
+func (h *MilestoneHandler) RemoveMilestoneTask(w http.ResponseWriter, r *http.Request) {
+	milestoneID, taskID, ok := milestoneTaskParams(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	if _, ok := h.milestoneForTasks(w, r, milestoneID); !ok {
+		return
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	change := h.audit.In(tx).Begin(ctx, audit.Task, taskID)
+	tag, err := tx.Exec(ctx, `UPDATE tasks SET milestone_id = NULL WHERE id = $1 AND milestone_id = $2`, taskID, milestoneID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, "Task is not linked to this milestone", http.StatusNotFound)
+		return
+	}
+	change.Record(ctx, audit.Update)
+
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// milestoneForTasks loads a milestone and checks that the caller may manage
+// the tasks of its project. It writes the error response and reports false
+// when the handler should stop.
+func (h *MilestoneHandler) milestoneForTasks(w http.ResponseWriter, r *http.Request, milestoneID int) (*models.Milestone, bool) {
+	milestone, err := h.loadMilestone(r.Context(), milestoneID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Milestone not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if !authorize(w, r, h.policy, auth.ManageTasks, milestone.ProjectID) {
+		return nil, false
+	}
+	return milestone, true
+}
+
+func (h *MilestoneHandler) loadMilestone(ctx context.Context, milestoneID int) (*models.Milestone, error) {
+	query := `
+        SELECT ` + milestoneColumns + `
+        FROM milestones m
+        WHERE m.id = $1`
+
+	var milestone models.Milestone
+	if err := scanMilestone(h.db.QueryRow(ctx, query, milestoneID), &milestone); err != nil {
+		return nil, err
+	}
+	return &milestone, nil
+}
+
+// withProgress computes the progress of milestones from their tasks and the
+// schedule of their project, starting today.
+func (h *MilestoneHandler) withProgress(ctx context.Context, milestones []models.Milestone) error {
+	options := h.options
+	options.Start = models.Today()
+	inputs := make(map[int]schedule.Input)
+	plans := make(map[int]*models.ProjectSchedule)
+	for i := range milestones {
+		milestone := &milestones[i]
+		input, ok := inputs[milestone.ProjectID]
+		if !ok {
+			var err error
+			if input, err = loadScheduleInput(ctx, h.db, milestone.ProjectID); err != nil {
+				return err
+			}
+			plan, err := schedule.Compute(input, options)
+			if err != nil {
+				return err
+			}
+			inputs[milestone.ProjectID], plans[milestone.ProjectID] = input, plan
+		}
+		milestone.Progress = schedule.Progress(milestone.ID, milestone.TargetDate, input.Tasks, plans[milestone.ProjectID], h.options)
+	}
+	return nil
+}
+
+func writeProgressError(w http.ResponseWriter, err error) {
+	if err == schedule.ErrCycle {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// checkMilestone validates milestoneID as the milestone of a task of
+// projectID: it must belong to the same project.
+func checkMilestone(ctx context.Context, db querier, projectID int, milestoneID *int) error {
+	if milestoneID == nil {
+		return nil
+	}
+
+	var milestoneProjectID int
+	err := db.QueryRow(ctx, `SELECT project_id FROM milestones WHERE id = $1`, *milestoneID).Scan(&milestoneProjectID)
+	if err == pgx.ErrNoRows {
+		return &taskRuleError{http.StatusBadRequest, "Milestone not found"}
+	}
+	if err != nil {
+		return err
+	}
+	if milestoneProjectID != projectID {
+		return &taskRuleError{http.StatusBadRequest, "A task can only be linked to a milestone of its project"}
+	}
+	return nil
+}
+
+func validateMilestone(milestone *models.Milestone) error {
+	milestone.Name = strings.TrimSpace(milestone.Name)
+	if milestone.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if utf8.RuneCountInString(milestone.Name) > maxMilestoneName {
+		return fmt.Errorf("name cannot be longer than %d characters", maxMilestoneName)
+	}
+	if utf8.RuneCountInString(milestone.Description) > maxMilestoneDescription {
+		return fmt.Errorf("description cannot be longer than %d characters", maxMilestoneDescription)
+	}
+	if milestone.TargetDate.IsZero() {
+		return fmt.Errorf("target_date is required")
+	}
+	return nil
+}
+
+func writeMilestoneError(w http.ResponseWriter, err error) {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		http.Error(w, "The project already has a milestone with this name", http.StatusConflict)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+func milestoneTaskParams(w http.ResponseWriter, r *http.Request) (milestoneID, taskID int, ok bool) {
+	vars := mux.Vars(r)
+	milestoneID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid milestone ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	taskID, err = strconv.Atoi(vars["taskId"])
+	if err != nil {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	return milestoneID, taskID, true
+}
@@ -1,9 +1,10 @@
 package handlers
 
 import (
-	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -11,26 +12,40 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"nstorm.com/main-backend/audit"
+	"nstorm.com/main-backend/auth"
+	"nstorm.com/main-backend/cache"
+	"nstorm.com/main-backend/metrics"
 	"nstorm.com/main-backend/models"
+	"nstorm.com/main-backend/planner"
 )
 
 type ProjectHandler struct {
-	db *pgx.Conn
+	db      *pgxpool.Pool
+	policy  *auth.Policy
+	audit   *audit.Recorder
+	planner *planner.Client
+	guard   *planner.Guard
+	cache   *cache.Store
 }
 
-func NewProjectHandler(db *pgx.Conn) *ProjectHandler {
-	return &ProjectHandler{db: db}
+func NewProjectHandler(db *pgxpool.Pool, policy *auth.Policy, audit *audit.Recorder, planner *planner.Client, guard *planner.Guard, cache *cache.Store) *ProjectHandler {
+	return &ProjectHandler{db: db, policy: policy, audit: audit, planner: planner, guard: guard, cache: cache}
 }
 
 func (h *ProjectHandler) CreateProject(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
+	if !authorize(w, r, h.policy, auth.CreateProject, 0) {
+		return
+	}
+
+	ctx := r.Context()
 	var project models.Project
 	if err := json.NewDecoder(r.Body).Decode(&project); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	fmt.Println(project.LeadID)
 	query := `
         INSERT INTO projects (name, description, lead_id)
         VALUES ($1, $2, $3)
@@ -47,6 +62,8 @@ func (h *ProjectHandler) CreateProject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.audit.Created(audit.Project, project.ID).Record(r.Context(), audit.Create)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(project)
 }
@@ -66,7 +83,7 @@ func (h *ProjectHandler) GetProjectByID(w http.ResponseWriter, r *http.Request)
         WHERE id = $1`
 
 	var project models.Project
-	err = h.db.QueryRow(context.Background(), query, projectID).Scan(
+	err = h.db.QueryRow(r.Context(), query, projectID).Scan(
 		&project.ID,
 		&project.Name,
 		&project.Description,
@@ -102,13 +119,18 @@ func (h *ProjectHandler) UpdateProject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !authorize(w, r, h.policy, auth.UpdateProject, projectID) {
+		return
+	}
+
 	query := `
         UPDATE projects 
         SET name = $1, description = $2, lead_id = $3
         WHERE id = $4
         RETURNING id, name, description, lead_id, created_at`
 
-	err = h.db.QueryRow(context.Background(), query,
+	change := h.audit.Begin(r.Context(), audit.Project, projectID)
+	err = h.db.QueryRow(r.Context(), query,
 		project.Name,
 		project.Description,
 		project.LeadID,
@@ -124,6 +146,8 @@ func (h *ProjectHandler) UpdateProject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	change.Record(r.Context(), audit.Update)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(project)
 }
@@ -137,8 +161,13 @@ func (h *ProjectHandler) DeleteProject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !authorize(w, r, h.policy, auth.DeleteProject, projectID) {
+		return
+	}
+
+	change := h.audit.Begin(r.Context(), audit.Project, projectID)
 	query := `DELETE FROM projects WHERE id = $1`
-	result, err := h.db.Exec(context.Background(), query, projectID)
+	result, err := h.db.Exec(r.Context(), query, projectID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -149,6 +178,8 @@ func (h *ProjectHandler) DeleteProject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	change.Record(r.Context(), audit.Delete)
+
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -158,7 +189,7 @@ func (h *ProjectHandler) GetAllProjects(w http.ResponseWriter, r *http.Request)
         SELECT id, name, description, lead_id, created_at 
         FROM projects`
 
-	rows, err := h.db.Query(context.Background(), query)
+	rows, err := h.db.Query(r.Context(), query)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -186,17 +217,6 @@ func (h *ProjectHandler) GetAllProjects(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(projects)
 }
 
-type ChatResponse struct {
-	Status  string           `json:"status"`
-	Message string           `json:"message"`
-	Tasks   []TaskAssignment `json:"tasks"`
-}
-
-type TaskAssignment struct {
-	Task       string `json:"task"`
-	AssignedTo string `json:"assigned_to"`
-}
-
 func (h *ProjectHandler) GenerateAndAssignTasks(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	projectID, err := strconv.Atoi(vars["id"])
@@ -214,14 +234,27 @@ func (h *ProjectHandler) GenerateAndAssignTasks(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	// Query employees and their skills for the project
+	if !authorize(w, r, h.policy, auth.GenerateTasks, projectID) {
+		return
+	}
+
+	// Values such as the caller are kept for auditing, but generation is not
+	// abandoned halfway through if the client disconnects
+	ctx := context.WithoutCancel(r.Context())
+
+	// Query employees and their skills for the project; observers and
+	// members whose membership is not current do not get tasks
 	query := `
         SELECT e.name, e.skills
         FROM employees e
         JOIN employee_projects ep ON e.id = ep.employee_id
-        WHERE ep.project_id = $1`
+        WHERE ep.project_id = $1
+          AND ep.role <> 'OBSERVER'
+          AND (ep.start_date IS NULL OR ep.start_date <= CURRENT_DATE)
+          AND (ep.end_date IS NULL OR ep.end_date >= CURRENT_DATE)
+        ORDER BY e.id`
 
-	rows, err := h.db.Query(context.Background(), query, projectID)
+	rows, err := h.db.Query(ctx, query, projectID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -230,7 +263,6 @@ func (h *ProjectHandler) GenerateAndAssignTasks(w http.ResponseWriter, r *http.R
 
 	// Build the prompt with employee skills
 	var employeeSkills []string
-	employeeNameMap := make(map[string]int)
 	for rows.Next() {
 		var name string
 		var skills []string
@@ -239,67 +271,170 @@ func (h *ProjectHandler) GenerateAndAssignTasks(w http.ResponseWriter, r *http.R
 			return
 		}
 		employeeSkills = append(employeeSkills, fmt.Sprintf("%s: %v", name, skills))
-		employeeNameMap[name] = 1
 	}
 
-	// Prepare the prompt
-	prompt := fmt.Sprintf("Project Requirements: %s\nTeam Members and Skills:\n%s",
-		req.Requirements,
-		strings.Join(employeeSkills, "\n"))
-
-	// Send request to chat endpoint
-	chatReq, err := http.NewRequest("POST", "http://localhost:8000/chat",
-		bytes.NewBufferString(fmt.Sprintf(`{"prompt": "%s"}`, prompt)))
+	// Prepare the prompt, masking PII and rejecting instruction overrides
+	filtered, err := h.guard.Filter(req.Requirements, employeeSkills)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.rejectRun(ctx, projectID, filtered, err)
+		if errors.Is(err, planner.ErrPromptTooLarge) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	chatReq.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(chatReq)
+	// Identical requests for the same team share one planner call
+	key := generationCacheKey(projectID, req.Requirements, employeeSkills)
+	resp, outcome, _ := h.cache.Do(key, "", func(w http.ResponseWriter) {
+		h.generate(ctx, w, projectID, filtered)
+	})
+	w.Header().Set("X-Generation-Cache", string(outcome))
+	resp.WriteTo(w)
+}
+
+// generationCacheKey identifies a generation request by project, requirements
+// and the team snapshot the prompt was built from.
+func generationCacheKey(projectID int, requirements string, employeeSkills []string) string {
+	requirementsSum := sha256.Sum256([]byte(requirements))
+	teamSum := sha256.Sum256([]byte(strings.Join(employeeSkills, "\n")))
+	return fmt.Sprintf("%d:%x:%x", projectID, requirementsSum, teamSum)
+}
+
+// generate runs the planner for an accepted request and stores the resulting
+// tasks, recording the run and its token usage along the way.
+func (h *ProjectHandler) generate(ctx context.Context, w http.ResponseWriter, projectID int, filtered *planner.Filtered) {
+	redactions := nonNilRedactions(filtered.Redactions)
+
+	// Record the run before calling the planner so failures are accounted for
+	// too. The run reserves its estimated prompt tokens against the budget
+	// until the planner reports the actual usage.
+	runID, err := h.startRun(ctx, projectID, filtered, redactions)
+	if err == errBudgetExceeded {
+		http.Error(w, err.Error(), http.StatusPaymentRequired)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer resp.Body.Close()
 
-	var chatResponse ChatResponse
-	if err := json.NewDecoder(resp.Body).Decode(&chatResponse); err != nil {
+	metrics.GenerationsInFlight.Inc()
+	chatResponse, err := h.planner.Generate(ctx, filtered.Prompt)
+	metrics.GenerationsInFlight.Dec()
+	if err != nil {
+		h.failRun(ctx, runID, err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	// Insert tasks into database
-	ctx := context.Background()
 	tx, err := h.db.Begin(ctx)
 	if err != nil {
+		h.failRun(ctx, runID, err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	defer tx.Rollback(ctx)
 
 	insertQuery := `
-        INSERT INTO tasks (project_id, title, assigned_to, status)
-        SELECT $1, $2, e.id, 'TODO'
+        INSERT INTO tasks (project_id, title, assigned_to, status, generation_run_id, generated_assigned_to)
+        SELECT $1, $2, e.id, 'TODO', $4, e.id
         FROM employees e
         WHERE e.name = $3
         RETURNING id`
 
 	for _, task := range chatResponse.Tasks {
 		var taskID int
-		err := tx.QueryRow(ctx, insertQuery, projectID, task.Task, task.AssignedTo).Scan(&taskID)
+		err := tx.QueryRow(ctx, insertQuery, projectID, task.Task, task.AssignedTo, runID).Scan(&taskID)
 		if err != nil {
+			tx.Rollback(ctx)
+			h.failRun(ctx, runID, err)
 			http.Error(w, fmt.Sprintf("Failed to insert task: %v", err), http.StatusInternalServerError)
 			return
 		}
+		h.audit.In(tx).Created(audit.Task, taskID).Record(ctx, audit.Create)
+	}
+
+	usage := chatResponse.Usage
+	_, err = tx.Exec(ctx, `
+        UPDATE generation_runs
+        SET status = 'SUCCEEDED', prompt_tokens = $1, completion_tokens = $2, total_tokens = $3,
+            tokens_estimated = $4, cost_usd = $5, completed_at = CURRENT_TIMESTAMP
+        WHERE id = $6`,
+		usage.PromptTokens, usage.CompletionTokens, usage.TotalTokens, usage.Estimated, usage.CostUSD, runID)
+	if err != nil {
+		tx.Rollback(ctx)
+		h.failRun(ctx, runID, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	if err := tx.Commit(ctx); err != nil {
+		h.failRun(ctx, runID, err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(chatResponse)
+	json.NewEncoder(w).Encode(struct {
+		*planner.ChatResponse
+		RunID      int                `json:"run_id"`
+		Redactions []models.Redaction `json:"redactions"`
+	}{chatResponse, runID, redactions})
+}
+
+// startRun checks the project's budget and records a running generation run
+// holding its estimated prompt tokens, both under the budget row lock so
+// concurrent runs cannot overdraw the budget together.
+func (h *ProjectHandler) startRun(ctx context.Context, projectID int, filtered *planner.Filtered, redactions []models.Redaction) (int, error) {
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	reserved := planner.EstimateTokens(filtered.Prompt)
+	if err := checkBudget(ctx, tx, projectID, reserved); err != nil {
+		return 0, err
+	}
+
+	var runID int
+	err = tx.QueryRow(ctx, `
+        INSERT INTO generation_runs (project_id, requirements, planner_backend, prompt_version, redactions,
+                                     prompt_tokens, total_tokens, tokens_estimated)
+        VALUES ($1, $2, $3, $4, $5, $6, $6, TRUE)
+        RETURNING id`,
+		projectID, filtered.Requirements, h.planner.Backend(), planner.PromptVersion, redactions, reserved,
+	).Scan(&runID)
+	if err != nil {
+		return 0, err
+	}
+	return runID, tx.Commit(ctx)
+}
+
+// rejectRun records a request the guard refused to send to the planner.
+// Errors are ignored since the caller is already reporting a failure.
+func (h *ProjectHandler) rejectRun(ctx context.Context, projectID int, filtered *planner.Filtered, cause error) {
+	h.db.Exec(ctx, `
+        INSERT INTO generation_runs (project_id, requirements, planner_backend, prompt_version, status, error, redactions, completed_at)
+        VALUES ($1, $2, $3, $4, 'REJECTED', $5, $6, CURRENT_TIMESTAMP)`,
+		projectID, filtered.Requirements, h.planner.Backend(), planner.PromptVersion, cause.Error(), nonNilRedactions(filtered.Redactions))
+}
+
+// failRun marks a generation run as failed. Errors are ignored since the
+// caller is already reporting a failure to the client.
+func (h *ProjectHandler) failRun(ctx context.Context, runID int, cause error) {
+	h.db.Exec(ctx, `
+        UPDATE generation_runs
+        SET status = 'FAILED', error = $1, completed_at = CURRENT_TIMESTAMP
+        WHERE id = $2`, cause.Error(), runID)
+}
+
+func nonNilRedactions(redactions []models.Redaction) []models.Redaction {
+	if redactions == nil {
+		return []models.Redaction{}
+	}
+	return redactions
 }
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"nstorm.com/main-backend/models"
+	"nstorm.com/main-backend/schedule"
+)
+
+type ScheduleHandler struct {
+	db      *pgxpool.Pool
+	options schedule.Options
+}
+
+// NewScheduleHandler uses hoursPerDay and hoursPerStoryPoint to turn task
+// estimates into working days.
+func NewScheduleHandler(db *pgxpool.Pool, hoursPerDay, hoursPerStoryPoint float64) *ScheduleHandler {
+	return &ScheduleHandler{db: db, options: schedule.Options{
+		HoursPerDay:        hoursPerDay,
+		HoursPerStoryPoint: hoursPerStoryPoint,
+		DefaultDays:        1,
+	}}
+}
+
+// GetProjectSchedule computes the earliest timeline of a project's open
+// tasks and its critical path. Work starts today unless start is given.
+func (h *ScheduleHandler) GetProjectSchedule(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	projectID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	options := h.options
+	options.Start = models.Today()
+	if value := r.URL.Query().Get("start"); value != "" {
+		if options.Start, err = models.ParseDate(value); err != nil {
+			http.Error(w, "start must be a date (YYYY-MM-DD)", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var exists bool
+	if err := h.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM projects WHERE id = $1)`, projectID).Scan(&exists); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	input, err := loadScheduleInput(ctx, h.db, projectID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result, err := schedule.Compute(input, options)
+	if err == schedule.ErrCycle {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// loadScheduleInput reads what schedule.Compute needs for a project: its
+// tasks with their prerequisites in other projects, the dependency edges and
+// the project's members with their allocation.
+func loadScheduleInput(ctx context.Context, db querier, projectID int) (schedule.Input, error) {
+	input := schedule.Input{ProjectID: projectID, Assignees: make(map[int]schedule.Assignee)}
+
+	query := `
+        SELECT ` + taskColumns + `
+        FROM tasks t
+        WHERE t.project_id = $1
+           OR t.id IN (SELECT d.depends_on_id FROM task_dependencies d JOIN tasks x ON x.id = d.task_id WHERE x.project_id = $1)
+        ORDER BY t.id`
+
+	rows, err := db.Query(ctx, query, projectID)
+	if err != nil {
+		return input, err
+	}
+	if input.Tasks, err = scanTasks(rows); err != nil {
+		return input, err
+	}
+
+	query = `
+        SELECT d.depends_on_id, d.task_id
+        FROM task_dependencies d
+        JOIN tasks t ON t.id = d.task_id
+        WHERE t.project_id = $1`
+
+	rows, err = db.Query(ctx, query, projectID)
+	if err != nil {
+		return input, err
+	}
+	input.Dependencies, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.DependencyEdge, error) {
+		var edge models.DependencyEdge
+		err := row.Scan(&edge.From, &edge.To)
+		return edge, err
+	})
+	if err != nil {
+		return input, err
+	}
+
+	query = `
+        SELECT employee_id, allocation_percent, start_date, end_date
+        FROM employee_projects
+        WHERE project_id = $1`
+
+	rows, err = db.Query(ctx, query, projectID)
+	if err != nil {
+		return input, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var employeeID int
+		var assignee schedule.Assignee
+		if err := rows.Scan(&employeeID, &assignee.AllocationPercent, &assignee.StartDate, &assignee.EndDate); err != nil {
+			return input, err
+		}
+		input.Assignees[employeeID] = assignee
+	}
+	return input, rows.Err()
+}
@@ -0,0 +1,739 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"nstorm.com/main-backend/audit"
+	"nstorm.com/main-backend/auth"
+	"nstorm.com/main-backend/models"
+)
+
+const (
+	maxSprintName = 100
+	maxSprintGoal = 2000
+)
+
+type SprintHandler struct {
+	db     *pgxpool.Pool
+	policy *auth.Policy
+	audit  *audit.Recorder
+}
+
+func NewSprintHandler(db *pgxpool.Pool, policy *auth.Policy, audit *audit.Recorder) *SprintHandler {
+	return &SprintHandler{db: db, policy: policy, audit: audit}
+}
+
+// sprintColumns is the select list read by scanSprint, for queries that
+// alias sprints as s and join sprintCounts. Closed sprints report the counts
+// saved when they were closed.
+const sprintColumns = `s.id, s.project_id, s.name, COALESCE(s.goal, ''), s.status, s.start_date, s.end_date,
+               s.started_at, s.closed_at, s.created_at, COALESCE(s.closed_tasks, c.tasks),
+               COALESCE(s.closed_done_tasks, c.done_tasks), COALESCE(s.closed_story_points, c.story_points),
+               COALESCE(s.closed_completed_story_points, c.completed_story_points)`
+
+// sprintCounts counts the current tasks of sprint s as c.
+const sprintCounts = `
+        CROSS JOIN LATERAL (
+            SELECT COUNT(*), COUNT(*) FILTER (WHERE status = 'DONE'),
+                   COALESCE(SUM(story_points), 0), COALESCE(SUM(story_points) FILTER (WHERE status = 'DONE'), 0)
+            FROM tasks
+            WHERE sprint_id = s.id
+        ) c(tasks, done_tasks, story_points, completed_story_points)`
+
+func scanSprint(row pgx.Row, sprint *models.Sprint) error {
+	return row.Scan(
+		&sprint.ID,
+		&sprint.ProjectID,
+		&sprint.Name,
+		&sprint.Goal,
+		&sprint.Status,
+		&sprint.StartDate,
+		&sprint.EndDate,
+		&sprint.StartedAt,
+		&sprint.ClosedAt,
+		&sprint.CreatedAt,
+		&sprint.TaskCount,
+		&sprint.DoneTaskCount,
+		&sprint.StoryPoints,
+		&sprint.CompletedStoryPoints,
+	)
+}
+
+// GetProjectSprints lists the sprints of a project by start date, optionally
+// only those with the given status.
+func (h *SprintHandler) GetProjectSprints(w http.ResponseWriter, r *http.Request) {
+	projectID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+	status := strings.ToUpper(r.URL.Query().Get("status"))
+	if status != "" && !validSprintStatus(status) {
+		http.Error(w, "status must be PLANNED, ACTIVE or CLOSED", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	var exists bool
+	if err := h.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM projects WHERE id = $1)`, projectID).Scan(&exists); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	query := `
+        SELECT ` + sprintColumns + `
+        FROM sprints s` + sprintCounts + `
+        WHERE s.project_id = $1 AND ($2 = '' OR s.status = $2)
+        ORDER BY s.start_date, s.id`
+
+	rows, err := h.db.Query(ctx, query, projectID, status)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	sprints := []models.Sprint{}
+	for rows.Next() {
+		var sprint models.Sprint
+		if err := scanSprint(rows, &sprint); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		sprints = append(sprints, sprint)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sprints)
+}
+
+// CreateSprint plans a new sprint for a project.
+func (h *SprintHandler) CreateSprint(w http.ResponseWriter, r *http.Request) {
+	projectID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	var sprint models.Sprint
+	if err := json.NewDecoder(r.Body).Decode(&sprint); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateSprint(&sprint); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	var exists bool
+	if err := h.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM projects WHERE id = $1)`, projectID).Scan(&exists); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	if !authorize(w, r, h.policy, auth.ManageTasks, projectID) {
+		return
+	}
+
+	query := `
+        INSERT INTO sprints (project_id, name, goal, start_date, end_date)
+        VALUES ($1, $2, NULLIF($3, ''), $4, $5)
+        RETURNING id`
+
+	var sprintID int
+	if err := h.db.QueryRow(ctx, query, projectID, sprint.Name, sprint.Goal, sprint.StartDate, sprint.EndDate).Scan(&sprintID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit.Created(audit.Sprint, sprintID).Record(ctx, audit.Create)
+
+	created, err := loadSprint(ctx, h.db, sprintID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+func (h *SprintHandler) GetSprint(w http.ResponseWriter, r *http.Request) {
+	sprintID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid sprint ID", http.StatusBadRequest)
+		return
+	}
+
+	sprint, err := loadSprint(r.Context(), h.db, sprintID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Sprint not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sprint)
+}
+
+// UpdateSprint changes the name, goal and dates of a sprint that is not
+// closed. The status only changes through the start and close routes.
+func (h *SprintHandler) UpdateSprint(w http.ResponseWriter, r *http.Request) {
+	sprintID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid sprint ID", http.StatusBadRequest)
+		return
+	}
+
+	var sprint models.Sprint
+	if err := json.NewDecoder(r.Body).Decode(&sprint); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateSprint(&sprint); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	current, ok := h.sprintForChange(w, r, sprintID)
+	if !ok {
+		return
+	}
+	if current.Status == models.SprintClosed {
+		http.Error(w, "A closed sprint cannot be changed", http.StatusConflict)
+		return
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	change := h.audit.In(tx).Begin(ctx, audit.Sprint, sprintID)
+	query := `
+        UPDATE sprints
+        SET name = $1, goal = NULLIF($2, ''), start_date = $3, end_date = $4
+        WHERE id = $5`
+	if _, err := tx.Exec(ctx, query, sprint.Name, sprint.Goal, sprint.StartDate, sprint.EndDate, sprintID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	change.Record(ctx, audit.Update)
+
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	updated, err := loadSprint(ctx, h.db, sprintID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// DeleteSprint deletes a planned sprint; its tasks go back to the backlog.
+// Sprints that were started are kept and closed instead.
+func (h *SprintHandler) DeleteSprint(w http.ResponseWriter, r *http.Request) {
+	sprintID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid sprint ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	sprint, ok := h.sprintForChange(w, r, sprintID)
+	if !ok {
+		return
+	}
+	if sprint.Status != models.SprintPlanned {
+		http.Error(w, "Only planned sprints can be deleted", http.StatusConflict)
+		return
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	change := h.audit.In(tx).Begin(ctx, audit.Sprint, sprintID)
+	if _, err := tx.Exec(ctx, `DELETE FROM sprints WHERE id = $1`, sprintID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	change.Record(ctx, audit.Delete)
+
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// StartSprint makes a planned sprint the active one of its project.
+func (h *SprintHandler) StartSprint(w http.ResponseWriter, r *http.Request) {
+	sprintID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid sprint ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	sprint, ok := h.sprintForChange(w, r, sprintID)
+	if !ok {
+		return
+	}
+	if sprint.Status != models.SprintPlanned {
+		http.Error(w, "Only a planned sprint can be started", http.StatusConflict)
+		return
+	}
+
+	var activeID int
+	err = h.db.QueryRow(ctx, `SELECT id FROM sprints WHERE project_id = $1 AND status = 'ACTIVE'`, sprint.ProjectID).Scan(&activeID)
+	if err == nil {
+		http.Error(w, fmt.Sprintf("Sprint %d is still active; close it first", activeID), http.StatusConflict)
+		return
+	}
+	if err != pgx.ErrNoRows {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	change := h.audit.In(tx).Begin(ctx, audit.Sprint, sprintID)
+	query := `UPDATE sprints SET status = 'ACTIVE', started_at = CURRENT_TIMESTAMP WHERE id = $1 AND status = 'PLANNED'`
+	if _, err := tx.Exec(ctx, query, sprintID); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			http.Error(w, "Another sprint of the project is active", http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	change.Record(ctx, audit.Update)
+
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	started, err := loadSprint(ctx, h.db, sprintID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(started)
+}
+
+// CloseSprint closes the active sprint and carries its unfinished tasks
+// over to the planned sprint named in carry_over_to, by default the next
+// planned sprint of the project, or back to the backlog when there is none.
+func (h *SprintHandler) CloseSprint(w http.ResponseWriter, r *http.Request) {
+	sprintID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid sprint ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		CarryOverTo *int `json:"carry_over_to"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	sprint, ok := h.sprintForChange(w, r, sprintID)
+	if !ok {
+		return
+	}
+	if sprint.Status != models.SprintActive {
+		http.Error(w, "Only the active sprint can be closed", http.StatusConflict)
+		return
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	// Lock the sprint so that no concurrent close carries the tasks twice
+	var status string
+	if err := tx.QueryRow(ctx, `SELECT status FROM sprints WHERE id = $1 FOR UPDATE`, sprintID).Scan(&status); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if status != models.SprintActive {
+		http.Error(w, "Only the active sprint can be closed", http.StatusConflict)
+		return
+	}
+
+	target := req.CarryOverTo
+	if target != nil {
+		var projectID int
+		err := tx.QueryRow(ctx, `SELECT project_id, status FROM sprints WHERE id = $1`, *target).Scan(&projectID, &status)
+		if err == pgx.ErrNoRows || (err == nil && projectID != sprint.ProjectID) {
+			http.Error(w, "carry_over_to must be a sprint of the same project", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if status != models.SprintPlanned {
+			http.Error(w, "Unfinished tasks can only be carried over to a planned sprint", http.StatusConflict)
+			return
+		}
+	} else {
+		query := `
+        SELECT id
+        FROM sprints
+        WHERE project_id = $1 AND status = 'PLANNED'
+        ORDER BY start_date, id
+        LIMIT 1`
+		var nextID int
+		err := tx.QueryRow(ctx, query, sprint.ProjectID).Scan(&nextID)
+		if err != nil && err != pgx.ErrNoRows {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err == nil {
+			target = &nextID
+		}
+	}
+
+	rows, err := tx.Query(ctx, `SELECT id FROM tasks WHERE sprint_id = $1 AND status <> $2 ORDER BY id`, sprintID, models.TaskStatusDone)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	unfinished, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Save what the sprint committed to and completed before its unfinished
+	// tasks leave it
+	query := `
+        UPDATE sprints
+        SET status = 'CLOSED', closed_at = CURRENT_TIMESTAMP, closed_tasks = c.tasks, closed_done_tasks = c.done_tasks,
+            closed_story_points = c.story_points, closed_completed_story_points = c.completed_story_points
+        FROM (SELECT c.* FROM sprints s` + sprintCounts + `
+              WHERE s.id = $1) c
+        WHERE sprints.id = $1`
+
+	recorder := h.audit.In(tx)
+	change := recorder.Begin(ctx, audit.Sprint, sprintID)
+	if _, err := tx.Exec(ctx, query, sprintID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	change.Record(ctx, audit.Update)
+
+	for _, taskID := range unfinished {
+		change := recorder.Begin(ctx, audit.Task, taskID)
+		if _, err := tx.Exec(ctx, `UPDATE tasks SET sprint_id = $1 WHERE id = $2`, target, taskID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		change.Record(ctx, audit.Update)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	closure := models.SprintClosure{CarriedOver: unfinished, CarriedOverTo: target}
+	if closure.CarriedOver == nil {
+		closure.CarriedOver = []int{}
+	}
+	closed, err := loadSprint(ctx, h.db, sprintID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	closure.Sprint = *closed
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(closure)
+}
+
+// GetSprintTasks lists the tasks of a sprint. It accepts the filters of the
+// other task listings.
+func (h *SprintHandler) GetSprintTasks(w http.ResponseWriter, r *http.Request) {
+	sprintID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid sprint ID", http.StatusBadRequest)
+		return
+	}
+	filter, err := parseTaskFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.where("t.sprint_id = $%d", sprintID)
+
+	ctx := r.Context()
+	var exists bool
+	if err := h.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM sprints WHERE id = $1)`, sprintID).Scan(&exists); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Sprint not found", http.StatusNotFound)
+		return
+	}
+
+	query := `
+        SELECT ` + taskColumns + `
+        FROM tasks t` + filter.clause() + `
+        ORDER BY t.id`
+
+	rows, err := h.db.Query(ctx, query, filter.args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tasks, err := scanTasks(rows)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if tasks == nil {
+		tasks = []models.Task{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tasks)
+}
+
+// AddSprintTask moves a task of the sprint's project into the sprint.
+func (h *SprintHandler) AddSprintTask(w http.ResponseWriter, r *http.Request) {
+	sprintID, taskID, ok := sprintTaskParams(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	sprint, ok := h.sprintForChange(w, r, sprintID)
+	if !ok {
+		return
+	}
+
+	var projectID int
+	var current *int
+	err := h.db.QueryRow(ctx, `SELECT project_id, sprint_id FROM tasks WHERE id = $1`, taskID).Scan(&projectID, &current)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := checkSprint(ctx, h.db, projectID, &sprint.ID, current); err != nil {
+		writeTaskRuleError(w, err)
+		return
+	}
+
+	change := h.audit.Begin(ctx, audit.Task, taskID)
+	query := `
+        UPDATE tasks t
+        SET sprint_id = $1
+        WHERE t.id = $2
+        RETURNING ` + taskColumns
+
+	var task models.Task
+	if err := scanTask(h.db.QueryRow(ctx, query, sprintID, taskID), &task); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	change.Record(ctx, audit.Update)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(task)
+}
+
+// RemoveSprintTask moves a task of a sprint that is not closed back to the
+// backlog.
+func (h *SprintHandler) RemoveSprintTask(w http.ResponseWriter, r *http.Request) {
+	sprintID, taskID, ok := sprintTaskParams(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	sprint, ok := h.sprintForChange(w, r, sprintID)
+	if !ok {
+		return
+	}
+	if sprint.Status == models.SprintClosed {
+		http.Error(w, "A closed sprint cannot be changed", http.StatusConflict)
+		return
+	}
+
+	change := h.audit.Begin(ctx, audit.Task, taskID)
+	tag, err := h.db.Exec(ctx, `UPDATE tasks SET sprint_id = NULL WHERE id = $1 AND sprint_id = $2`, taskID, sprintID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, "Task is not in this sprint", http.StatusNotFound)
+		return
+	}
+	change.Record(ctx, audit.Update)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sprintForChange loads a sprint and checks that the caller may manage the
+// tasks of its project. It writes the error response and reports false when
+// the handler should stop.
+func (h *SprintHandler) sprintForChange(w http.ResponseWriter, r *http.Request, sprintID int) (*models.Sprint, bool) {
+	sprint, err := loadSprint(r.Context(), h.db, sprintID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Sprint not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if !authorize(w, r, h.policy, auth.ManageTasks, sprint.ProjectID) {
+		return nil, false
+	}
+	return sprint, true
+}
+
+func loadSprint(ctx context.Context, db querier, sprintID int) (*models.Sprint, error) {
+	query := `
+        SELECT ` + sprintColumns + `
+        FROM sprints s` + sprintCounts + `
+        WHERE s.id = $1`
+
+	var sprint models.Sprint
+	if err := scanSprint(db.QueryRow(ctx, query, sprintID), &sprint); err != nil {
+		return nil, err
+	}
+	return &sprint, nil
+}
+
+// checkSprint validates sprintID as the sprint of a task of projectID whose
+// current sprint is current: the sprint belongs to the same project and is
+// not closed, unless the task is already in it.
+func checkSprint(ctx context.Context, db querier, projectID int, sprintID, current *int) error {
+	if sprintID == nil {
+		return nil
+	}
+
+	var sprintProjectID int
+	var status string
+	err := db.QueryRow(ctx, `SELECT project_id, status FROM sprints WHERE id = $1`, *sprintID).Scan(&sprintProjectID, &status)
+	if err == pgx.ErrNoRows {
+		return &taskRuleError{http.StatusBadRequest, "Sprint not found"}
+	}
+	if err != nil {
+		return err
+	}
+	if sprintProjectID != projectID {
+		return &taskRuleError{http.StatusBadRequest, "A task can only be in a sprint of its project"}
+	}
+	if status == models.SprintClosed && (current == nil || *current != *sprintID) {
+		return &taskRuleError{http.StatusConflict, "Sprint is closed"}
+	}
+	return nil
+}
+
+func validateSprint(sprint *models.Sprint) error {
+	sprint.Name = strings.TrimSpace(sprint.Name)
+	if sprint.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if utf8.RuneCountInString(sprint.Name) > maxSprintName {
+		return fmt.Errorf("name cannot be longer than %d characters", maxSprintName)
+	}
+	if utf8.RuneCountInString(sprint.Goal) > maxSprintGoal {
+		return fmt.Errorf("goal cannot be longer than %d characters", maxSprintGoal)
+	}
+	if sprint.StartDate.IsZero() || sprint.EndDate.IsZero() {
+		return fmt.Errorf("start_date and end_date are required")
+	}
+	if sprint.EndDate.Before(sprint.StartDate.Time) {
+		return fmt.Errorf("end_date must not be before start_date")
+	}
+	return nil
+}
+
+func validSprintStatus(status string) bool {
+	switch status {
+	case models.SprintPlanned, models.SprintActive, models.SprintClosed:
+		return true
+	}
+	return false
+}
+
+func sprintTaskParams(w http.ResponseWriter, r *http.Request) (sprintID, taskID int, ok bool) {
+	vars := mux.Vars(r)
+	sprintID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid sprint ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	taskID, err = strconv.Atoi(vars["taskId"])
+	if err != nil {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	return sprintID, taskID, true
+}
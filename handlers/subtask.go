@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"nstorm.com/main-backend/models"
+)
+
+// checkHierarchy validates task against its parent and, for an existing
+// task (taskID != 0), against its subtasks: a parent lives in the same
+// project, is not one of the task's own subtasks, and cannot be done while
+// a subtask is open.
+func checkHierarchy(ctx context.Context, db querier, taskID int, task *models.Task) error {
+	if parentID := task.ParentTaskID; parentID != nil {
+		if *parentID == taskID {
+			return &taskRuleError{http.StatusBadRequest, "A task cannot be its own parent"}
+		}
+
+		var projectID int
+		var status string
+		err := db.QueryRow(ctx, `SELECT project_id, status FROM tasks WHERE id = $1`, *parentID).Scan(&projectID, &status)
+		if err == pgx.ErrNoRows {
+			return &taskRuleError{http.StatusBadRequest, "Parent task not found"}
+		}
+		if err != nil {
+			return err
+		}
+		if projectID != task.ProjectID {
+			return &taskRuleError{http.StatusBadRequest, "A subtask must belong to the project of its parent"}
+		}
+		if status == models.TaskStatusDone && task.Status != models.TaskStatusDone {
+			return &taskRuleError{http.StatusConflict, "Parent task is done; reopen it first"}
+		}
+
+		if taskID != 0 {
+			cycle, err := isSubtaskOf(ctx, db, *parentID, taskID)
+			if err != nil {
+				return err
+			}
+			if cycle {
+				return &taskRuleError{http.StatusConflict, "A task cannot be moved under its own subtask"}
+			}
+		}
+	}
+
+	if taskID == 0 {
+		return nil
+	}
+
+	var subtasks, open, elsewhere int
+	query := `
+        SELECT COUNT(*), COUNT(*) FILTER (WHERE status <> $2), COUNT(*) FILTER (WHERE project_id <> $3)
+        FROM tasks
+        WHERE parent_task_id = $1`
+	err := db.QueryRow(ctx, query, taskID, models.TaskStatusDone, task.ProjectID).Scan(&subtasks, &open, &elsewhere)
+	if err != nil {
+		return err
+	}
+	if elsewhere > 0 {
+		return &taskRuleError{http.StatusConflict, "Move or detach the subtasks before moving the task to another project"}
+	}
+	if open > 0 && task.Status == models.TaskStatusDone {
+		return &taskRuleError{http.StatusConflict, "Task has open subtasks"}
+	}
+	return nil
+}
+
+// isSubtaskOf reports whether taskID is below ancestorID in the hierarchy.
+func isSubtaskOf(ctx context.Context, db querier, taskID, ancestorID int) (bool, error) {
+	query := `
+        WITH RECURSIVE ancestors(id) AS (
+            SELECT parent_task_id FROM tasks WHERE id = $1
+            UNION
+            SELECT t.parent_task_id
+            FROM tasks t
+            JOIN ancestors a ON t.id = a.id
+        )
+        SELECT EXISTS (SELECT 1 FROM ancestors WHERE id = $2)`
+
+	var found bool
+	err := db.QueryRow(ctx, query, taskID, ancestorID).Scan(&found)
+	return found, err
+}
+
+// CreateSubtask creates a task under the task in the path. The subtask
+// inherits the project of its parent.
+func (h *TaskHandler) CreateSubtask(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	parentID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return
+	}
+
+	var task models.Task
+	if err := json.NewDecoder(r.Body).Decode(&task); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	parent, err := h.loadTask(r.Context(), parentID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	task.ProjectID = parent.ProjectID
+	task.ParentTaskID = &parent.ID
+	h.createTask(w, r, &task)
+}
+
+// GetSubtasks lists the direct subtasks of a task.
+func (h *TaskHandler) GetSubtasks(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	taskID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.loadTask(r.Context(), taskID); err == pgx.ErrNoRows {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	query := `
+        SELECT ` + taskColumns + `
+        FROM tasks t
+        WHERE t.parent_task_id = $1
+        ORDER BY t.id`
+
+	rows, err := h.db.Query(r.Context(), query, taskID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	subtasks, err := scanTasks(rows)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if subtasks == nil {
+		subtasks = []models.Task{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(subtasks)
+}
+
+// GetTaskTree returns a task with all of its subtasks nested below it, each
+// parent carrying the rolled up status and estimates of its subtasks.
+func (h *TaskHandler) GetTaskTree(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	taskID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return
+	}
+
+	query := `
+        WITH RECURSIVE tree(id) AS (
+            SELECT id FROM tasks WHERE id = $1
+            UNION
+            SELECT s.id
+            FROM tasks s
+            JOIN tree ON s.parent_task_id = tree.id
+        )
+        SELECT ` + taskColumns + `
+        FROM tasks t
+        JOIN tree ON tree.id = t.id
+        ORDER BY t.id`
+
+	rows, err := h.db.Query(r.Context(), query, taskID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tasks, err := scanTasks(rows)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(tasks) == 0 {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+
+	children := make(map[int][]models.Task)
+	var root models.Task
+	for _, task := range tasks {
+		if task.ID == taskID {
+			root = task
+			continue
+		}
+		children[*task.ParentTaskID] = append(children[*task.ParentTaskID], task)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildTaskTree(root, children))
+}
+
+func buildTaskTree(task models.Task, children map[int][]models.Task) models.TaskTree {
+	tree := models.TaskTree{Task: task, Subtasks: []models.TaskTree{}}
+	if len(children[task.ID]) == 0 {
+		return tree
+	}
+
+	rollup := &models.TaskRollup{}
+	var started, blocked int
+	for _, child := range children[task.ID] {
+		subtree := buildTaskTree(child, children)
+		tree.Subtasks = append(tree.Subtasks, subtree)
+
+		// Count the child itself, then everything below it. The work of a
+		// child with subtasks is that of its subtasks, so only leaves add
+		// their own estimates.
+		counts := []models.TaskRollup{{Subtasks: 1}}
+		if subtree.Rollup != nil {
+			counts = append(counts, *subtree.Rollup)
+		} else {
+			counts[0].StoryPoints = deref(child.StoryPoints)
+			counts[0].EstimateHours = deref(child.EstimateHours)
+			counts[0].ActualHours = deref(child.ActualHours)
+		}
+		switch child.Status {
+		case models.TaskStatusDone:
+			counts[0].DoneSubtasks = 1
+		case models.TaskStatusInProgress:
+			started++
+		case models.TaskStatusBlocked:
+			blocked++
+		}
+		if subtree.Rollup != nil && subtree.Rollup.Status == models.TaskStatusInProgress {
+			started++
+		}
+		for _, c := range counts {
+			rollup.Subtasks += c.Subtasks
+			rollup.DoneSubtasks += c.DoneSubtasks
+			rollup.StoryPoints += c.StoryPoints
+			rollup.EstimateHours += c.EstimateHours
+			rollup.ActualHours += c.ActualHours
+		}
+	}
+
+	n := len(children[task.ID])
+	switch {
+	case rollup.DoneSubtasks == rollup.Subtasks:
+		rollup.Status = models.TaskStatusDone
+	case started > 0 || rollup.DoneSubtasks > 0:
+		rollup.Status = models.TaskStatusInProgress
+	case blocked == n:
+		rollup.Status = models.TaskStatusBlocked
+	default:
+		rollup.Status = models.TaskStatusTodo
+	}
+	tree.Rollup = rollup
+	return tree
+}
+
+func deref[T int | float64](v *T) T {
+	if v == nil {
+		return 0
+	}
+	return *v
+}
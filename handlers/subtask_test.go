@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"testing"
+
+	"nstorm.com/main-backend/models"
+)
+
+func TestBuildTaskTreeRollsUpLeaves(t *testing.T) {
+	points := func(n int) *int { return &n }
+	hours := func(h float64) *float64 { return &h }
+	parent := func(id int) *int { return &id }
+
+	// 1 ── 2 (has its own estimate, but also subtasks) ── 4, 5
+	//   └─ 3
+	root := models.Task{ID: 1, Status: models.TaskStatusInProgress, StoryPoints: points(40), EstimateHours: hours(100)}
+	children := map[int][]models.Task{
+		1: {
+			{ID: 2, ParentTaskID: parent(1), Status: models.TaskStatusInProgress, StoryPoints: points(13), EstimateHours: hours(20), ActualHours: hours(9)},
+			{ID: 3, ParentTaskID: parent(1), Status: models.TaskStatusDone, StoryPoints: points(2), EstimateHours: hours(3), ActualHours: hours(4)},
+		},
+		2: {
+			{ID: 4, ParentTaskID: parent(2), Status: models.TaskStatusDone, StoryPoints: points(3), EstimateHours: hours(5), ActualHours: hours(6)},
+			{ID: 5, ParentTaskID: parent(2), Status: models.TaskStatusTodo, StoryPoints: points(5), EstimateHours: hours(8)},
+		},
+	}
+
+	tree := buildTaskTree(root, children)
+
+	want := models.TaskRollup{
+		Status:        models.TaskStatusInProgress,
+		Subtasks:      4,
+		DoneSubtasks:  2,
+		StoryPoints:   10,
+		EstimateHours: 16,
+		ActualHours:   10,
+	}
+	if tree.Rollup == nil || *tree.Rollup != want {
+		t.Errorf("root rollup = %+v, want %+v", tree.Rollup, want)
+	}
+
+	middle := tree.Subtasks[0]
+	want = models.TaskRollup{
+		Status:        models.TaskStatusInProgress,
+		Subtasks:      2,
+		DoneSubtasks:  1,
+		StoryPoints:   8,
+		EstimateHours: 13,
+		ActualHours:   6,
+	}
+	if middle.Rollup == nil || *middle.Rollup != want {
+		t.Errorf("task 2 rollup = %+v, want %+v", middle.Rollup, want)
+	}
+	for _, leaf := range []models.TaskTree{tree.Subtasks[1], middle.Subtasks[0], middle.Subtasks[1]} {
+		if leaf.Rollup != nil {
+			t.Errorf("leaf %d has a rollup", leaf.ID)
+		}
+	}
+}
@@ -8,36 +8,114 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"nstorm.com/main-backend/audit"
+	"nstorm.com/main-backend/auth"
 	"nstorm.com/main-backend/models"
 )
 
 type TaskHandler struct {
-	db *pgx.Conn
+	db     *pgxpool.Pool
+	policy *auth.Policy
+	audit  *audit.Recorder
 }
 
-func NewTaskHandler(db *pgx.Conn) *TaskHandler {
-	return &TaskHandler{db: db}
+func NewTaskHandler(db *pgxpool.Pool, policy *auth.Policy, audit *audit.Recorder) *TaskHandler {
+	return &TaskHandler{db: db, policy: policy, audit: audit}
+}
+
+// loadTask fetches the current state of a task for authorization checks
+func (h *TaskHandler) loadTask(ctx context.Context, taskID int) (*models.Task, error) {
+	query := `
+        SELECT ` + taskColumns + `
+        FROM tasks t
+        WHERE t.id = $1`
+
+	var task models.Task
+	err := scanTask(h.db.QueryRow(ctx, query, taskID), &task)
+	if err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// syncBlocked re-derives the BLOCKED status of task and of the tasks that
+// depend on it after task was changed.
+func (h *TaskHandler) syncBlocked(ctx context.Context, task *models.Task) error {
+	dependents, err := dependentsOf(ctx, h.db, task.ID)
+	if err != nil {
+		return err
+	}
+	changes, err := refreshBlockedStatus(ctx, h.db, h.audit, append(dependents, task.ID))
+	if err != nil {
+		return err
+	}
+	if status, ok := changes[task.ID]; ok {
+		task.Status = status
+	}
+	return nil
 }
 
 func (h *TaskHandler) CreateTask(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
 	var task models.Task
 	if err := json.NewDecoder(r.Body).Decode(&task); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	h.createTask(w, r, &task)
+}
+
+// createTask validates and inserts a decoded task. It is shared by the
+// task and subtask routes.
+func (h *TaskHandler) createTask(w http.ResponseWriter, r *http.Request, task *models.Task) {
+	ctx := r.Context()
+	if err := validateTask(task); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !authorize(w, r, h.policy, auth.ManageTasks, task.ProjectID) {
+		return
+	}
+
+	if err := checkStatusChange(ctx, h.db, 0, "", task.Status); err != nil {
+		writeTaskRuleError(w, err)
+		return
+	}
+	if err := checkHierarchy(ctx, h.db, 0, task); err != nil {
+		writeTaskRuleError(w, err)
+		return
+	}
+	if err := checkSprint(ctx, h.db, task.ProjectID, task.SprintID, nil); err != nil {
+		writeTaskRuleError(w, err)
+		return
+	}
+	if err := checkMilestone(ctx, h.db, task.ProjectID, task.MilestoneID); err != nil {
+		writeTaskRuleError(w, err)
+		return
+	}
 
 	query := `
-        INSERT INTO tasks (project_id, assigned_to, title, description, status)
-        VALUES ($1, $2, $3, $4, $5)
+        INSERT INTO tasks (project_id, assigned_to, parent_task_id, sprint_id, milestone_id, title, description, status,
+                           priority, start_date, due_date, story_points, estimate_hours, actual_hours)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
         RETURNING id, created_at`
 
 	err := h.db.QueryRow(ctx, query,
 		task.ProjectID,
 		task.AssignedTo,
+		task.ParentTaskID,
+		task.SprintID,
+		task.MilestoneID,
 		task.Title,
 		task.Description,
 		task.Status,
+		task.Priority,
+		task.StartDate,
+		task.DueDate,
+		task.StoryPoints,
+		task.EstimateHours,
+		task.ActualHours,
 	).Scan(&task.ID, &task.CreatedAt)
 
 	if err != nil {
@@ -45,6 +123,8 @@ func (h *TaskHandler) CreateTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.audit.Created(audit.Task, task.ID).Record(r.Context(), audit.Create)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(task)
 }
@@ -57,22 +137,7 @@ func (h *TaskHandler) GetTaskByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	query := `
-        SELECT id, project_id, assigned_to, title, description, status, created_at
-        FROM tasks 
-        WHERE id = $1`
-
-	var task models.Task
-	err = h.db.QueryRow(context.Background(), query, taskID).Scan(
-		&task.ID,
-		&task.ProjectID,
-		&task.AssignedTo,
-		&task.Title,
-		&task.Description,
-		&task.Status,
-		&task.CreatedAt,
-	)
-
+	task, err := h.loadTask(r.Context(), taskID)
 	if err == pgx.ErrNoRows {
 		http.Error(w, "Task not found", http.StatusNotFound)
 		return
@@ -99,21 +164,67 @@ func (h *TaskHandler) UpdateTask(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if err := validateTask(&task); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	current, err := h.loadTask(r.Context(), taskID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !checkAuthorization(w, h.policy.AuthorizeTaskUpdate(r.Context(), auth.FromContext(r.Context()), *current, task)) {
+		return
+	}
+	if err := checkStatusChange(r.Context(), h.db, taskID, current.Status, task.Status); err != nil {
+		writeTaskRuleError(w, err)
+		return
+	}
+	if err := checkHierarchy(r.Context(), h.db, taskID, &task); err != nil {
+		writeTaskRuleError(w, err)
+		return
+	}
+	if err := checkSprint(r.Context(), h.db, task.ProjectID, task.SprintID, current.SprintID); err != nil {
+		writeTaskRuleError(w, err)
+		return
+	}
+	if err := checkMilestone(r.Context(), h.db, task.ProjectID, task.MilestoneID); err != nil {
+		writeTaskRuleError(w, err)
+		return
+	}
 
 	query := `
-        UPDATE tasks 
-        SET project_id = $1, assigned_to = $2, title = $3, description = $4, status = $5
-        WHERE id = $6
-        RETURNING id, project_id, assigned_to, title, description, status, created_at`
+        UPDATE tasks t
+        SET project_id = $1, assigned_to = $2, parent_task_id = $3, sprint_id = $4, milestone_id = $5, title = $6,
+            description = $7, status = $8, priority = $9, start_date = $10, due_date = $11, story_points = $12,
+            estimate_hours = $13, actual_hours = $14,
+            status_before_blocked = CASE WHEN $8 = 'BLOCKED' THEN t.status_before_blocked END
+        WHERE t.id = $15
+        RETURNING ` + taskColumns
 
-	err = h.db.QueryRow(context.Background(), query,
+	change := h.audit.Begin(r.Context(), audit.Task, taskID)
+	err = scanTask(h.db.QueryRow(r.Context(), query,
 		task.ProjectID,
 		task.AssignedTo,
+		task.ParentTaskID,
+		task.SprintID,
+		task.MilestoneID,
 		task.Title,
 		task.Description,
 		task.Status,
+		task.Priority,
+		task.StartDate,
+		task.DueDate,
+		task.StoryPoints,
+		task.EstimateHours,
+		task.ActualHours,
 		taskID,
-	).Scan(&task.ID, &task.ProjectID, &task.AssignedTo, &task.Title, &task.Description, &task.Status, &task.CreatedAt)
+	), &task)
 
 	if err != nil {
 		if err == pgx.ErrNoRows {
@@ -124,6 +235,87 @@ func (h *TaskHandler) UpdateTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if task.AssignedTo != current.AssignedTo {
+		change.Record(r.Context(), audit.Assign)
+	} else {
+		change.Record(r.Context(), audit.Update)
+	}
+
+	if err := h.syncBlocked(r.Context(), &task); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(task)
+}
+
+// UpdateTaskStatus changes only the status of a task. It is the route
+// developers use for tasks assigned to them.
+func (h *TaskHandler) UpdateTaskStatus(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	taskID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Status == "" {
+		http.Error(w, "Status is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	task, err := h.loadTask(ctx, taskID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	updated := *task
+	updated.Status = req.Status
+	if !checkAuthorization(w, h.policy.AuthorizeTaskUpdate(r.Context(), auth.FromContext(r.Context()), *task, updated)) {
+		return
+	}
+	if err := checkStatusChange(ctx, h.db, taskID, task.Status, updated.Status); err != nil {
+		writeTaskRuleError(w, err)
+		return
+	}
+	if err := checkHierarchy(ctx, h.db, taskID, &updated); err != nil {
+		writeTaskRuleError(w, err)
+		return
+	}
+
+	change := h.audit.Begin(r.Context(), audit.Task, taskID)
+	query := `
+        UPDATE tasks
+        SET status = $1, status_before_blocked = CASE WHEN $1 = 'BLOCKED' THEN status_before_blocked END
+        WHERE id = $2
+        RETURNING status`
+	err = h.db.QueryRow(ctx, query, req.Status, taskID).Scan(&task.Status)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	change.Record(r.Context(), audit.Update)
+
+	if err := h.syncBlocked(ctx, task); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(task)
 }
@@ -136,8 +328,29 @@ func (h *TaskHandler) DeleteTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	current, err := h.loadTask(r.Context(), taskID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !authorize(w, r, h.policy, auth.ManageTasks, current.ProjectID) {
+		return
+	}
+
+	// Tasks waiting on this one may have nothing left blocking them
+	dependents, err := dependentsOf(r.Context(), h.db, taskID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	change := h.audit.Begin(r.Context(), audit.Task, taskID)
 	query := `DELETE FROM tasks WHERE id = $1`
-	result, err := h.db.Exec(context.Background(), query, taskID)
+	result, err := h.db.Exec(r.Context(), query, taskID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -148,38 +361,38 @@ func (h *TaskHandler) DeleteTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	change.Record(r.Context(), audit.Delete)
+
+	if _, err := refreshBlockedStatus(r.Context(), h.db, h.audit, dependents); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
 }
 
+// GetAllTasks lists tasks, optionally filtered by overdue, due_before and
+// priority.
 func (h *TaskHandler) GetAllTasks(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseTaskFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	query := `
-        SELECT id, project_id, assigned_to, title, description, status, created_at
-        FROM tasks`
+        SELECT ` + taskColumns + `
+        FROM tasks t` + filter.clause()
 
-	rows, err := h.db.Query(context.Background(), query)
+	rows, err := h.db.Query(r.Context(), query, filter.args...)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	var tasks []models.Task
-	for rows.Next() {
-		var task models.Task
-		err := rows.Scan(
-			&task.ID,
-			&task.ProjectID,
-			&task.AssignedTo,
-			&task.Title,
-			&task.Description,
-			&task.Status,
-			&task.CreatedAt,
-		)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		tasks = append(tasks, task)
+	tasks, err := scanTasks(rows)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
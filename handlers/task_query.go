@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"nstorm.com/main-backend/models"
+)
+
+// taskColumns is the select list read by scanTask, for queries that alias
+// tasks as t. Nullable text columns are coalesced so that tasks created
+// without an assignee or description still scan. Labels are read as a JSON
+// array; tracked hours are summed from the finished time entries.
+const taskColumns = `t.id, t.project_id, COALESCE(t.assigned_to, 0), t.parent_task_id, t.sprint_id, t.milestone_id, t.title, COALESCE(t.description, ''), t.status,
+               t.priority, t.start_date, t.due_date, t.story_points, t.estimate_hours, t.actual_hours,
+               (SELECT ROUND(SUM(EXTRACT(EPOCH FROM te.ended_at - te.started_at))::numeric / 3600, 2)::float8
+                FROM time_entries te WHERE te.task_id = t.id AND te.ended_at IS NOT NULL),
+               t.created_at,
+               (SELECT COALESCE(jsonb_agg(to_jsonb(l) ORDER BY l.name), '[]') FROM task_labels tl JOIN labels l ON l.id = tl.label_id WHERE tl.task_id = t.id)`
+
+func scanTask(row pgx.Row, task *models.Task) error {
+	return row.Scan(
+		&task.ID,
+		&task.ProjectID,
+		&task.AssignedTo,
+		&task.ParentTaskID,
+		&task.SprintID,
+		&task.MilestoneID,
+		&task.Title,
+		&task.Description,
+		&task.Status,
+		&task.Priority,
+		&task.StartDate,
+		&task.DueDate,
+		&task.StoryPoints,
+		&task.EstimateHours,
+		&task.ActualHours,
+		&task.TrackedHours,
+		&task.CreatedAt,
+		&task.Labels,
+	)
+}
+
+func scanTasks(rows pgx.Rows) ([]models.Task, error) {
+	defer rows.Close()
+
+	var tasks []models.Task
+	for rows.Next() {
+		var task models.Task
+		if err := scanTask(rows, &task); err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, rows.Err()
+}
+
+// taskFilter collects WHERE conditions and their arguments for task listings.
+type taskFilter struct {
+	conditions []string
+	args       []any
+}
+
+// where adds a condition. condition contains a single %d, which is replaced
+// by the placeholder number of arg.
+func (f *taskFilter) where(condition string, arg any) {
+	f.args = append(f.args, arg)
+	f.conditions = append(f.conditions, fmt.Sprintf(condition, len(f.args)))
+}
+
+func (f *taskFilter) clause() string {
+	if len(f.conditions) == 0 {
+		return ""
+	}
+	return "\n        WHERE " + strings.Join(f.conditions, " AND ")
+}
+
+// parseTaskFilter reads the filters shared by task listings: overdue=true|false,
+// due_before=YYYY-MM-DD and priority, which may list several priorities
+// separated by commas. Overdue tasks are past their due date and not done.
+// labels lists label names separated by commas; label_match=all keeps only
+// tasks that carry all of them, the default any those with at least one.
+// sprint_id keeps the tasks of one sprint, or of the backlog when "none";
+// milestone_id likewise those of a milestone, or of none.
+func parseTaskFilter(r *http.Request) (*taskFilter, error) {
+	params := r.URL.Query()
+	filter := &taskFilter{}
+
+	if value := params.Get("overdue"); value != "" {
+		overdue, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid overdue value")
+		}
+		condition := "(t.due_date < CURRENT_DATE AND t.status <> $%d)"
+		if !overdue {
+			// Tasks without a due date are never overdue
+			condition = "(t.due_date IS NULL OR t.due_date >= CURRENT_DATE OR t.status = $%d)"
+		}
+		filter.where(condition, models.TaskStatusDone)
+	}
+
+	if value := params.Get("due_before"); value != "" {
+		dueBefore, err := models.ParseDate(value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid due_before date")
+		}
+		filter.where("t.due_date < $%d", dueBefore)
+	}
+
+	if value := params.Get("priority"); value != "" {
+		var priorities []string
+		for _, p := range strings.Split(value, ",") {
+			priority := models.TaskPriority(strings.ToUpper(strings.TrimSpace(p)))
+			if !priority.Valid() {
+				return nil, fmt.Errorf("Invalid priority %q", p)
+			}
+			priorities = append(priorities, string(priority))
+		}
+		filter.where("t.priority = ANY($%d)", priorities)
+	}
+
+	if value := params.Get("labels"); value != "" {
+		names := []string{}
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				names = append(names, name)
+			}
+		}
+		if len(names) == 0 {
+			return nil, fmt.Errorf("Invalid labels value")
+		}
+		labeled := "SELECT lower(l.name) FROM task_labels tl JOIN labels l ON l.id = tl.label_id WHERE tl.task_id = t.id AND lower(l.name) = ANY($%[1]d)"
+		switch params.Get("label_match") {
+		case "", "any":
+			filter.where("EXISTS ("+labeled+")", names)
+		case "all":
+			filter.where("(SELECT COUNT(DISTINCT n) FROM ("+labeled+") AS matched(n)) = cardinality($%[1]d::text[])", uniqueStrings(names))
+		default:
+			return nil, fmt.Errorf("label_match must be any or all")
+		}
+	}
+
+	switch value := params.Get("sprint_id"); value {
+	case "":
+	case "none":
+		filter.conditions = append(filter.conditions, "t.sprint_id IS NULL")
+	default:
+		sprintID, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("sprint_id must be a sprint ID or none")
+		}
+		filter.where("t.sprint_id = $%d", sprintID)
+	}
+
+	switch value := params.Get("milestone_id"); value {
+	case "":
+	case "none":
+		filter.conditions = append(filter.conditions, "t.milestone_id IS NULL")
+	default:
+		milestoneID, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("milestone_id must be a milestone ID or none")
+		}
+		filter.where("t.milestone_id = $%d", milestoneID)
+	}
+
+	return filter, nil
+}
+
+// validateTask applies defaults to the planning fields of task and checks
+// them.
+func validateTask(task *models.Task) error {
+	if task.Priority == "" {
+		task.Priority = models.PriorityMedium
+	}
+	if !task.Priority.Valid() {
+		return fmt.Errorf("Invalid priority")
+	}
+	if task.StoryPoints != nil && *task.StoryPoints < 0 {
+		return fmt.Errorf("story_points must not be negative")
+	}
+	if (task.EstimateHours != nil && *task.EstimateHours < 0) || (task.ActualHours != nil && *task.ActualHours < 0) {
+		return fmt.Errorf("Hours must not be negative")
+	}
+	if task.StartDate != nil && task.DueDate != nil && task.DueDate.Before(task.StartDate.Time) {
+		return fmt.Errorf("due_date must not be before start_date")
+	}
+	return nil
+}
+
+// checkStatusChange rejects setting a task to BLOCKED by hand, since the
+// status follows the task's prerequisites and would not be cleared, and
+// finishing a task before all of its prerequisites are done. taskID is 0 for
+// a new task, which has no prerequisites yet.
+func checkStatusChange(ctx context.Context, db querier, taskID int, current, status string) error {
+	if status == models.TaskStatusBlocked && current != models.TaskStatusBlocked {
+		return &taskRuleError{http.StatusBadRequest, "BLOCKED is set from the task's dependencies; add a prerequisite instead"}
+	}
+	if status != models.TaskStatusDone || current == models.TaskStatusDone || taskID == 0 {
+		return nil
+	}
+
+	query := `
+        SELECT EXISTS (
+            SELECT 1
+            FROM task_dependencies d
+            JOIN tasks p ON p.id = d.depends_on_id
+            WHERE d.task_id = $1 AND p.status <> 'DONE')`
+
+	var open bool
+	if err := db.QueryRow(ctx, query, taskID).Scan(&open); err != nil {
+		return err
+	}
+	if open {
+		return &taskRuleError{http.StatusConflict, "Task cannot be DONE while a prerequisite is not DONE"}
+	}
+	return nil
+}
+
+// taskRuleError is a change that breaks the subtask, sprint or milestone
+// rules of a task. status is the response code to answer with.
+type taskRuleError struct {
+	status  int
+	message string
+}
+
+func (e *taskRuleError) Error() string {
+	return e.message
+}
+
+// writeTaskRuleError answers with the status of a taskRuleError, or 500 for
+// any other error.
+func writeTaskRuleError(w http.ResponseWriter, err error) {
+	if e, ok := err.(*taskRuleError); ok {
+		http.Error(w, e.message, e.status)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	unique := values[:0]
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,623 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"nstorm.com/main-backend/audit"
+	"nstorm.com/main-backend/auth"
+	"nstorm.com/main-backend/models"
+)
+
+const (
+	defaultTimeEntryLimit = 100
+	maxTimeEntryLimit     = 1000
+	maxTimeEntryDuration  = 24 * time.Hour
+	maxTimeEntryNote      = 2000
+	// clockSkew is tolerated between the caller's clock and ours when
+	// rejecting entries in the future.
+	clockSkew = time.Minute
+)
+
+type TimeHandler struct {
+	db     *pgxpool.Pool
+	policy *auth.Policy
+	audit  *audit.Recorder
+}
+
+func NewTimeHandler(db *pgxpool.Pool, policy *auth.Policy, audit *audit.Recorder) *TimeHandler {
+	return &TimeHandler{db: db, policy: policy, audit: audit}
+}
+
+// timeEntryColumns is the select list read by scanTimeEntry, for queries
+// that alias time_entries as te. Running timers count up to now.
+const timeEntryColumns = `te.id, te.task_id, te.employee_id, te.started_at, te.ended_at,
+               ROUND(EXTRACT(EPOCH FROM COALESCE(te.ended_at, CURRENT_TIMESTAMP) - te.started_at)::numeric / 3600, 2)::float8,
+               COALESCE(te.note, ''), te.created_at`
+
+func scanTimeEntry(row pgx.Row, entry *models.TimeEntry) error {
+	return row.Scan(
+		&entry.ID,
+		&entry.TaskID,
+		&entry.EmployeeID,
+		&entry.StartedAt,
+		&entry.EndedAt,
+		&entry.Hours,
+		&entry.Note,
+		&entry.CreatedAt,
+	)
+}
+
+// trackedTask is what authorizing time tracking needs to know of a task.
+type trackedTask struct {
+	projectID  int
+	assignedTo int
+}
+
+// GetTaskTimeEntries lists the time entries of a task, newest first.
+// employee_id narrows the list to one employee.
+func (h *TimeHandler) GetTaskTimeEntries(w http.ResponseWriter, r *http.Request) {
+	taskID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return
+	}
+	var employeeID *int
+	if value := r.URL.Query().Get("employee_id"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "Invalid employee ID", http.StatusBadRequest)
+			return
+		}
+		employeeID = &id
+	}
+	limit, offset, ok := pageParams(w, r, defaultTimeEntryLimit, maxTimeEntryLimit)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	if _, err := h.loadTrackedTask(ctx, taskID); err == pgx.ErrNoRows {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	query := `
+        SELECT ` + timeEntryColumns + `
+        FROM time_entries te
+        WHERE te.task_id = $1 AND ($2::integer IS NULL OR te.employee_id = $2)
+        ORDER BY te.started_at DESC, te.id DESC
+        LIMIT $3 OFFSET $4`
+
+	rows, err := h.db.Query(ctx, query, taskID, employeeID, limit, offset)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	entries := []models.TimeEntry{}
+	for rows.Next() {
+		var entry models.TimeEntry
+		if err := scanTimeEntry(rows, &entry); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// CreateTimeEntry logs finished work on a task after the fact. The entry
+// belongs to the caller unless employee_id names someone else, which needs
+// the right to manage the task's project.
+func (h *TimeHandler) CreateTimeEntry(w http.ResponseWriter, r *http.Request) {
+	taskID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return
+	}
+
+	var entry models.TimeEntry
+	if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if entry.EndedAt == nil {
+		http.Error(w, "ended_at is required; start a timer to track running time", http.StatusBadRequest)
+		return
+	}
+	if err := validateTimeEntry(&entry, time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	principal := auth.FromContext(ctx)
+	if entry.EmployeeID == 0 {
+		if principal.EmployeeID == 0 {
+			http.Error(w, "employee_id is required for service accounts", http.StatusBadRequest)
+			return
+		}
+		entry.EmployeeID = principal.EmployeeID
+	}
+
+	task, err := h.loadTrackedTask(ctx, taskID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !checkAuthorization(w, h.authorizeTracking(ctx, principal, entry.EmployeeID, task)) {
+		return
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	if overlap, err := overlappingEntry(ctx, tx, entry.EmployeeID, 0, entry.StartedAt, entry.EndedAt); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if overlap != 0 {
+		http.Error(w, fmt.Sprintf("Overlaps time entry %d", overlap), http.StatusConflict)
+		return
+	}
+
+	query := `
+        INSERT INTO time_entries AS te (task_id, employee_id, started_at, ended_at, note)
+        VALUES ($1, $2, $3, $4, NULLIF($5, ''))
+        RETURNING ` + timeEntryColumns
+
+	err = scanTimeEntry(tx.QueryRow(ctx, query,
+		taskID,
+		entry.EmployeeID,
+		entry.StartedAt,
+		entry.EndedAt,
+		entry.Note,
+	), &entry)
+	if err != nil {
+		writeTimeEntryError(w, err)
+		return
+	}
+	h.audit.In(tx).Created(audit.TimeEntry, entry.ID).Record(ctx, audit.Create)
+
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(entry)
+}
+
+// StartTimer starts a running time entry for the caller on a task. An
+// employee runs at most one timer at a time.
+func (h *TimeHandler) StartTimer(w http.ResponseWriter, r *http.Request) {
+	taskID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return
+	}
+	note, ok := decodeTimerNote(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	principal := auth.FromContext(ctx)
+	if principal.EmployeeID == 0 {
+		http.Error(w, "Only employees can run timers", http.StatusBadRequest)
+		return
+	}
+
+	task, err := h.loadTrackedTask(ctx, taskID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !checkAuthorization(w, h.authorizeTracking(ctx, principal, principal.EmployeeID, task)) {
+		return
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var runningTaskID int
+	err = tx.QueryRow(ctx, `SELECT task_id FROM time_entries WHERE employee_id = $1 AND ended_at IS NULL`, principal.EmployeeID).Scan(&runningTaskID)
+	if err == nil {
+		http.Error(w, fmt.Sprintf("A timer is already running on task %d", runningTaskID), http.StatusConflict)
+		return
+	}
+	if err != pgx.ErrNoRows {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var entry models.TimeEntry
+	query := `
+        INSERT INTO time_entries AS te (task_id, employee_id, started_at, note)
+        VALUES ($1, $2, CURRENT_TIMESTAMP, NULLIF($3, ''))
+        RETURNING ` + timeEntryColumns
+
+	if err := scanTimeEntry(tx.QueryRow(ctx, query, taskID, principal.EmployeeID, note), &entry); err != nil {
+		writeTimeEntryError(w, err)
+		return
+	}
+	h.audit.In(tx).Created(audit.TimeEntry, entry.ID).Record(ctx, audit.Create)
+
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(entry)
+}
+
+// StopTimer stops the caller's running timer on a task. A note in the body
+// replaces the one given when the timer was started.
+func (h *TimeHandler) StopTimer(w http.ResponseWriter, r *http.Request) {
+	taskID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return
+	}
+	note, ok := decodeTimerNote(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	principal := auth.FromContext(ctx)
+	if principal.EmployeeID == 0 {
+		http.Error(w, "Only employees can run timers", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var entryID int
+	query := `SELECT id FROM time_entries WHERE task_id = $1 AND employee_id = $2 AND ended_at IS NULL FOR UPDATE`
+	err = tx.QueryRow(ctx, query, taskID, principal.EmployeeID).Scan(&entryID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "No timer is running on this task", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var entry models.TimeEntry
+	change := h.audit.In(tx).Begin(ctx, audit.TimeEntry, entryID)
+	query = `
+        UPDATE time_entries te
+        SET ended_at = GREATEST(CURRENT_TIMESTAMP, te.started_at + INTERVAL '1 second'),
+            note = COALESCE(NULLIF($2, ''), te.note)
+        WHERE te.id = $1
+        RETURNING ` + timeEntryColumns
+
+	if err := scanTimeEntry(tx.QueryRow(ctx, query, entryID, note), &entry); err != nil {
+		writeTimeEntryError(w, err)
+		return
+	}
+	change.Record(ctx, audit.Update)
+
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entry)
+}
+
+func (h *TimeHandler) GetTimeEntry(w http.ResponseWriter, r *http.Request) {
+	entryID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid time entry ID", http.StatusBadRequest)
+		return
+	}
+
+	entry, err := h.loadTimeEntry(r.Context(), h.db, entryID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Time entry not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entry)
+}
+
+// UpdateTimeEntry corrects the times or the note of an entry. Its employee
+// and anyone allowed to manage the task's project may change it. A running
+// timer may be given an end; a finished entry cannot be reopened.
+func (h *TimeHandler) UpdateTimeEntry(w http.ResponseWriter, r *http.Request) {
+	entryID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid time entry ID", http.StatusBadRequest)
+		return
+	}
+
+	var updated models.TimeEntry
+	if err := json.NewDecoder(r.Body).Decode(&updated); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateTimeEntry(&updated, time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	current, err := h.loadTimeEntry(ctx, tx, entryID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Time entry not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !h.authorizeEntry(w, r, current) {
+		return
+	}
+	if current.EndedAt != nil && updated.EndedAt == nil {
+		http.Error(w, "A finished time entry cannot be reopened", http.StatusBadRequest)
+		return
+	}
+
+	if overlap, err := overlappingEntry(ctx, tx, current.EmployeeID, entryID, updated.StartedAt, updated.EndedAt); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if overlap != 0 {
+		http.Error(w, fmt.Sprintf("Overlaps time entry %d", overlap), http.StatusConflict)
+		return
+	}
+
+	change := h.audit.In(tx).Begin(ctx, audit.TimeEntry, entryID)
+	query := `
+        UPDATE time_entries te
+        SET started_at = $2, ended_at = $3, note = NULLIF($4, '')
+        WHERE te.id = $1
+        RETURNING ` + timeEntryColumns
+
+	if err := scanTimeEntry(tx.QueryRow(ctx, query, entryID, updated.StartedAt, updated.EndedAt, updated.Note), &updated); err != nil {
+		writeTimeEntryError(w, err)
+		return
+	}
+	change.Record(ctx, audit.Update)
+
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// DeleteTimeEntry removes an entry, including a running timer. Its
+// employee and anyone allowed to manage the task's project may delete it.
+func (h *TimeHandler) DeleteTimeEntry(w http.ResponseWriter, r *http.Request) {
+	entryID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid time entry ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	entry, err := h.loadTimeEntry(ctx, tx, entryID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Time entry not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !h.authorizeEntry(w, r, entry) {
+		return
+	}
+
+	change := h.audit.In(tx).Begin(ctx, audit.TimeEntry, entryID)
+	if _, err := tx.Exec(ctx, `DELETE FROM time_entries WHERE id = $1`, entryID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	change.Record(ctx, audit.Delete)
+
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *TimeHandler) loadTrackedTask(ctx context.Context, taskID int) (trackedTask, error) {
+	var task trackedTask
+	err := h.db.QueryRow(ctx, `SELECT COALESCE(project_id, 0), COALESCE(assigned_to, 0) FROM tasks WHERE id = $1`, taskID).Scan(&task.projectID, &task.assignedTo)
+	return task, err
+}
+
+func (h *TimeHandler) loadTimeEntry(ctx context.Context, db querier, entryID int) (*models.TimeEntry, error) {
+	query := `
+        SELECT ` + timeEntryColumns + `
+        FROM time_entries te
+        WHERE te.id = $1`
+
+	var entry models.TimeEntry
+	if err := scanTimeEntry(db.QueryRow(ctx, query, entryID), &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// authorizeTracking checks that principal may track time of employeeID on
+// task. Principals allowed to manage tasks on the project may track anyone's
+// time; everyone else only their own, on tasks assigned to them.
+func (h *TimeHandler) authorizeTracking(ctx context.Context, principal *auth.Principal, employeeID int, task trackedTask) error {
+	err := h.policy.Authorize(ctx, principal, auth.ManageTasks, task.projectID)
+	if err != auth.ErrForbidden {
+		return err
+	}
+	if principal.EmployeeID == 0 || principal.EmployeeID != employeeID || task.assignedTo != employeeID {
+		return auth.ErrForbidden
+	}
+	return nil
+}
+
+// authorizeEntry lets the employee of entry through and otherwise requires
+// the right to manage the tasks of its project, writing a 403 if missing.
+func (h *TimeHandler) authorizeEntry(w http.ResponseWriter, r *http.Request, entry *models.TimeEntry) bool {
+	principal := auth.FromContext(r.Context())
+	if principal.EmployeeID != 0 && principal.EmployeeID == entry.EmployeeID {
+		return true
+	}
+	task, err := h.loadTrackedTask(r.Context(), entry.TaskID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	return authorize(w, r, h.policy, auth.ManageTasks, task.projectID)
+}
+
+// overlappingEntry returns the ID of an entry of employeeID other than
+// excludeID that overlaps the given span, or 0. A nil end is still running.
+func overlappingEntry(ctx context.Context, db querier, employeeID, excludeID int, start time.Time, end *time.Time) (int, error) {
+	query := `
+        SELECT id
+        FROM time_entries
+        WHERE employee_id = $1 AND id <> $2
+          AND tstzrange(started_at, ended_at) && tstzrange($3, $4)
+        ORDER BY started_at
+        LIMIT 1`
+
+	var id int
+	err := db.QueryRow(ctx, query, employeeID, excludeID, start, end).Scan(&id)
+	if err == pgx.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+func validateTimeEntry(entry *models.TimeEntry, now time.Time) error {
+	if entry.StartedAt.IsZero() {
+		return fmt.Errorf("started_at is required")
+	}
+	if entry.StartedAt.After(now.Add(clockSkew)) {
+		return fmt.Errorf("started_at cannot be in the future")
+	}
+	if end := entry.EndedAt; end != nil {
+		if !end.After(entry.StartedAt) {
+			return fmt.Errorf("ended_at must be after started_at")
+		}
+		if end.After(now.Add(clockSkew)) {
+			return fmt.Errorf("ended_at cannot be in the future")
+		}
+		if end.Sub(entry.StartedAt) > maxTimeEntryDuration {
+			return fmt.Errorf("a time entry cannot be longer than %s", maxTimeEntryDuration)
+		}
+	}
+	if utf8.RuneCountInString(entry.Note) > maxTimeEntryNote {
+		return fmt.Errorf("note cannot be longer than %d characters", maxTimeEntryNote)
+	}
+	return nil
+}
+
+// decodeTimerNote reads the optional {"note": ...} body of the timer routes.
+func decodeTimerNote(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req struct {
+		Note string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	if utf8.RuneCountInString(req.Note) > maxTimeEntryNote {
+		http.Error(w, fmt.Sprintf("note cannot be longer than %d characters", maxTimeEntryNote), http.StatusBadRequest)
+		return "", false
+	}
+	return req.Note, true
+}
+
+// writeTimeEntryError maps the constraints of time_entries to client errors
+// for writes that raced past the checks in the handlers.
+func writeTimeEntryError(w http.ResponseWriter, err error) {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "23P01":
+			http.Error(w, "Overlaps another time entry", http.StatusConflict)
+			return
+		case "23505":
+			http.Error(w, "A timer is already running", http.StatusConflict)
+			return
+		case "23503":
+			http.Error(w, "Employee not found", http.StatusBadRequest)
+			return
+		}
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
@@ -0,0 +1,318 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"nstorm.com/main-backend/models"
+)
+
+// maxTimesheetDays bounds the date range of a project timesheet.
+const maxTimesheetDays = 366
+
+// GetEmployeeTimesheet returns an employee's finished time entries of one
+// ISO week (week=YYYY-Www, default the current week) as hours per task and
+// day. Entries count on the UTC day they started. format=csv, or Accept:
+// text/csv, exports the same table as CSV.
+func (h *TimeHandler) GetEmployeeTimesheet(w http.ResponseWriter, r *http.Request) {
+	employeeID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid employee ID", http.StatusBadRequest)
+		return
+	}
+	asCSV, ok := timesheetFormat(w, r)
+	if !ok {
+		return
+	}
+	monday := weekStart(models.Today())
+	if value := r.URL.Query().Get("week"); value != "" {
+		if monday, err = parseISOWeek(value); err != nil {
+			http.Error(w, "week must be an ISO week (YYYY-Www)", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx := r.Context()
+	var exists bool
+	if err := h.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM employees WHERE id = $1)`, employeeID).Scan(&exists); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Employee not found", http.StatusNotFound)
+		return
+	}
+
+	query := `
+        SELECT te.task_id, t.title, COALESCE(t.project_id, 0), COALESCE(p.name, ''),
+               (te.started_at AT TIME ZONE 'UTC')::date,
+               SUM(EXTRACT(EPOCH FROM te.ended_at - te.started_at))::float8 / 3600
+        FROM time_entries te
+        JOIN tasks t ON t.id = te.task_id
+        LEFT JOIN projects p ON p.id = t.project_id
+        WHERE te.employee_id = $1 AND te.ended_at IS NOT NULL
+          AND te.started_at >= $2 AND te.started_at < $3
+        GROUP BY 1, 2, 3, 4, 5
+        ORDER BY 4, 1`
+
+	rows, err := h.db.Query(ctx, query, employeeID, monday.Time, monday.AddDate(0, 0, 7))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	sheet := models.WeeklyTimesheet{
+		EmployeeID:  employeeID,
+		Week:        isoWeek(monday),
+		Rows:        []models.TimesheetRow{},
+		DailyTotals: make([]float64, 7),
+	}
+	for i := range 7 {
+		sheet.Days = append(sheet.Days, models.Date{Time: monday.AddDate(0, 0, i)})
+	}
+	index := make(map[int]int)
+	for rows.Next() {
+		var row models.TimesheetRow
+		var day models.Date
+		var hours float64
+		if err := rows.Scan(&row.TaskID, &row.TaskTitle, &row.ProjectID, &row.ProjectName, &day, &hours); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		i, ok := index[row.TaskID]
+		if !ok {
+			row.Hours = make([]float64, 7)
+			sheet.Rows = append(sheet.Rows, row)
+			i = len(sheet.Rows) - 1
+			index[row.TaskID] = i
+		}
+		d := int(day.Sub(monday.Time).Hours() / 24)
+		sheet.Rows[i].Hours[d] += hours
+		sheet.Rows[i].TotalHours += hours
+		sheet.DailyTotals[d] += hours
+		sheet.TotalHours += hours
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for i := range sheet.Rows {
+		row := &sheet.Rows[i]
+		for d := range row.Hours {
+			row.Hours[d] = roundHours(row.Hours[d])
+		}
+		row.TotalHours = roundHours(row.TotalHours)
+	}
+	for d := range sheet.DailyTotals {
+		sheet.DailyTotals[d] = roundHours(sheet.DailyTotals[d])
+	}
+	sheet.TotalHours = roundHours(sheet.TotalHours)
+
+	if !asCSV {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sheet)
+		return
+	}
+
+	header := []string{"project_id", "project", "task_id", "task"}
+	for _, day := range sheet.Days {
+		header = append(header, day.String())
+	}
+	records := [][]string{append(header, "total")}
+	for _, row := range sheet.Rows {
+		record := []string{strconv.Itoa(row.ProjectID), row.ProjectName, strconv.Itoa(row.TaskID), row.TaskTitle}
+		records = append(records, append(appendHours(record, row.Hours), formatHours(row.TotalHours)))
+	}
+	total := appendHours([]string{"", "", "", "Total"}, sheet.DailyTotals)
+	records = append(records, append(total, formatHours(sheet.TotalHours)))
+
+	writeCSV(w, fmt.Sprintf("timesheet-%d-%s.csv", employeeID, sheet.Week), records)
+}
+
+// GetProjectTimesheet returns the finished time entries on a project's tasks
+// as hours per employee and ISO week, for entries started between from and
+// to (inclusive, default the last four weeks up to today). format=csv, or
+// Accept: text/csv, exports the rows as CSV.
+func (h *TimeHandler) GetProjectTimesheet(w http.ResponseWriter, r *http.Request) {
+	projectID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+	asCSV, ok := timesheetFormat(w, r)
+	if !ok {
+		return
+	}
+
+	params := r.URL.Query()
+	to := models.Today()
+	if value := params.Get("to"); value != "" {
+		if to, err = models.ParseDate(value); err != nil {
+			http.Error(w, "to must be a date (YYYY-MM-DD)", http.StatusBadRequest)
+			return
+		}
+	}
+	from := models.Date{Time: to.AddDate(0, 0, -27)}
+	if value := params.Get("from"); value != "" {
+		if from, err = models.ParseDate(value); err != nil {
+			http.Error(w, "from must be a date (YYYY-MM-DD)", http.StatusBadRequest)
+			return
+		}
+	}
+	if to.Before(from.Time) {
+		http.Error(w, "to must not be before from", http.StatusBadRequest)
+		return
+	}
+	if to.Sub(from.Time) >= maxTimesheetDays*24*time.Hour {
+		http.Error(w, fmt.Sprintf("A timesheet covers at most %d days", maxTimesheetDays), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	var exists bool
+	if err := h.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM projects WHERE id = $1)`, projectID).Scan(&exists); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	query := `
+        SELECT te.employee_id, e.name,
+               date_trunc('week', te.started_at AT TIME ZONE 'UTC')::date,
+               SUM(EXTRACT(EPOCH FROM te.ended_at - te.started_at))::float8 / 3600
+        FROM time_entries te
+        JOIN tasks t ON t.id = te.task_id
+        JOIN employees e ON e.id = te.employee_id
+        WHERE t.project_id = $1 AND te.ended_at IS NOT NULL
+          AND te.started_at >= $2 AND te.started_at < $3
+        GROUP BY 1, 2, 3
+        ORDER BY 3, 2, 1`
+
+	rows, err := h.db.Query(ctx, query, projectID, from.Time, to.AddDate(0, 0, 1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	sheet := models.ProjectTimesheet{ProjectID: projectID, From: from, To: to, Rows: []models.ProjectTimesheetRow{}}
+	for rows.Next() {
+		var row models.ProjectTimesheetRow
+		if err := rows.Scan(&row.EmployeeID, &row.EmployeeName, &row.WeekStart, &row.Hours); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		row.Week = isoWeek(row.WeekStart)
+		sheet.TotalHours += row.Hours
+		row.Hours = roundHours(row.Hours)
+		sheet.Rows = append(sheet.Rows, row)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sheet.TotalHours = roundHours(sheet.TotalHours)
+
+	if !asCSV {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sheet)
+		return
+	}
+
+	records := [][]string{{"week", "week_start", "employee_id", "employee", "hours"}}
+	for _, row := range sheet.Rows {
+		records = append(records, []string{
+			row.Week,
+			row.WeekStart.String(),
+			strconv.Itoa(row.EmployeeID),
+			row.EmployeeName,
+			formatHours(row.Hours),
+		})
+	}
+	records = append(records, []string{"", "", "", "Total", formatHours(sheet.TotalHours)})
+
+	writeCSV(w, fmt.Sprintf("timesheet-project-%d-%s-%s.csv", projectID, from, to), records)
+}
+
+// timesheetFormat reports whether the caller asked for CSV rather than
+// JSON, through format=csv or an Accept header naming text/csv.
+func timesheetFormat(w http.ResponseWriter, r *http.Request) (asCSV, ok bool) {
+	switch r.URL.Query().Get("format") {
+	case "csv":
+		return true, true
+	case "json":
+		return false, true
+	case "":
+		return strings.Contains(r.Header.Get("Accept"), "text/csv"), true
+	}
+	http.Error(w, "format must be json or csv", http.StatusBadRequest)
+	return false, false
+}
+
+// writeCSV sends records as a CSV download. Cells a spreadsheet would read
+// as a formula, such as task titles starting with = or with a tab or carriage
+// return in front of one, get a leading quote.
+func writeCSV(w http.ResponseWriter, filename string, records [][]string) {
+	for _, record := range records {
+		for i, cell := range record {
+			if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+				record[i] = "'" + cell
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	csv.NewWriter(w).WriteAll(records)
+}
+
+func appendHours(record []string, hours []float64) []string {
+	for _, h := range hours {
+		record = append(record, formatHours(h))
+	}
+	return record
+}
+
+func formatHours(hours float64) string {
+	return strconv.FormatFloat(hours, 'f', 2, 64)
+}
+
+func roundHours(hours float64) float64 {
+	return math.Round(hours*100) / 100
+}
+
+// weekStart returns the Monday of the ISO week of day.
+func weekStart(day models.Date) models.Date {
+	return models.Date{Time: day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))}
+}
+
+func isoWeek(day models.Date) string {
+	year, week := day.ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
+
+// parseISOWeek returns the Monday of an ISO week written as YYYY-Www.
+func parseISOWeek(value string) (models.Date, error) {
+	var year, week int
+	if _, err := fmt.Sscanf(value, "%4d-W%2d", &year, &week); err != nil {
+		return models.Date{}, err
+	}
+	// January 4th always falls in week 1
+	monday := weekStart(models.NewDate(year, time.January, 4))
+	monday.Time = monday.AddDate(0, 0, (week-1)*7)
+	if isoWeek(monday) != value {
+		return models.Date{}, fmt.Errorf("invalid ISO week %q", value)
+	}
+	return monday, nil
+}
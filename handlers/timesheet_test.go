@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"nstorm.com/main-backend/models"
+)
+
+func TestParseISOWeek(t *testing.T) {
+	tests := []struct {
+		value string
+		want  models.Date
+		ok    bool
+	}{
+		{"2026-W43", models.NewDate(2026, time.October, 19), true},
+		{"2026-W01", models.NewDate(2025, time.December, 29), true},
+		{"2020-W53", models.NewDate(2020, time.December, 28), true},
+		{"2026-W53", models.NewDate(2026, time.December, 28), true},
+		{"2021-W01", models.NewDate(2021, time.January, 4), true},
+
+		{"2025-W53", models.Date{}, false},
+		{"2027-W53", models.Date{}, false},
+		{"2026-W00", models.Date{}, false},
+		{"2026-W1", models.Date{}, false},
+		{"2026W01", models.Date{}, false},
+		{"W01-2026", models.Date{}, false},
+	}
+	for _, tt := range tests {
+		got, err := parseISOWeek(tt.value)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseISOWeek(%q) error = %v, want ok %v", tt.value, err, tt.ok)
+			continue
+		}
+		if tt.ok && !got.Equal(tt.want.Time) {
+			t.Errorf("parseISOWeek(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestWeekStartAndISOWeek(t *testing.T) {
+	tests := []struct {
+		day    models.Date
+		monday models.Date
+		week   string
+	}{
+		{models.NewDate(2026, time.October, 19), models.NewDate(2026, time.October, 19), "2026-W43"},
+		{models.NewDate(2026, time.October, 25), models.NewDate(2026, time.October, 19), "2026-W43"},
+		{models.NewDate(2026, time.January, 1), models.NewDate(2025, time.December, 29), "2026-W01"},
+		{models.NewDate(2027, time.January, 3), models.NewDate(2026, time.December, 28), "2026-W53"},
+		{models.NewDate(2021, time.January, 3), models.NewDate(2020, time.December, 28), "2020-W53"},
+		{models.NewDate(2024, time.December, 30), models.NewDate(2024, time.December, 30), "2025-W01"},
+	}
+	for _, tt := range tests {
+		if got := weekStart(tt.day); !got.Equal(tt.monday.Time) {
+			t.Errorf("weekStart(%s) = %s, want %s", tt.day, got, tt.monday)
+		}
+		if got := isoWeek(tt.day); got != tt.week {
+			t.Errorf("isoWeek(%s) = %s, want %s", tt.day, got, tt.week)
+		}
+	}
+}
+
+func TestWriteCSVEscapesFormulas(t *testing.T) {
+	records := [][]string{
+		{"=SUM(A1:A9)", "+1", "-2", "@cmd", "\t=1", "\r=1"},
+		{"Fix login", "a=b", "", "1.50", " =1", "2026-W43"},
+	}
+	want := [][]string{
+		{"'=SUM(A1:A9)", "'+1", "'-2", "'@cmd", "'\t=1", "'\r=1"},
+		{"Fix login", "a=b", "", "1.50", " =1", "2026-W43"},
+	}
+
+	rec := httptest.NewRecorder()
+	writeCSV(rec, "timesheet.csv", records)
+
+	if got := rec.Header().Get("Content-Disposition"); got != `attachment; filename="timesheet.csv"` {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+	got, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
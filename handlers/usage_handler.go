@@ -0,0 +1,359 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"nstorm.com/main-backend/auth"
+	"nstorm.com/main-backend/models"
+)
+
+var errBudgetExceeded = errors.New("token budget exceeded for this project")
+
+const (
+	defaultRunLimit = 50
+	maxRunLimit     = 200
+)
+
+type UsageHandler struct {
+	db     *pgxpool.Pool
+	policy *auth.Policy
+}
+
+func NewUsageHandler(db *pgxpool.Pool, policy *auth.Policy) *UsageHandler {
+	return &UsageHandler{db: db, policy: policy}
+}
+
+// checkBudget returns errBudgetExceeded when reserving another reserve tokens
+// would take the project past its monthly or lifetime token allowance.
+// Projects without a budget row are unlimited. The budget row stays locked
+// until tx ends, so the caller must record the run with its reservation in
+// the same transaction for concurrent runs to see it.
+func checkBudget(ctx context.Context, tx pgx.Tx, projectID, reserve int) error {
+	var monthlyLimit, totalLimit *int
+	err := tx.QueryRow(ctx, `
+        SELECT monthly_token_limit, total_token_limit
+        FROM project_budgets
+        WHERE project_id = $1
+        FOR UPDATE`, projectID).Scan(&monthlyLimit, &totalLimit)
+	if err == pgx.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var monthlyUsed, totalUsed int
+	err = tx.QueryRow(ctx, `
+        SELECT COALESCE(SUM(total_tokens) FILTER (WHERE created_at >= date_trunc('month', now())), 0),
+               COALESCE(SUM(total_tokens), 0)
+        FROM generation_runs
+        WHERE project_id = $1`, projectID).Scan(&monthlyUsed, &totalUsed)
+	if err != nil {
+		return err
+	}
+
+	if monthlyLimit != nil && monthlyUsed+reserve > *monthlyLimit {
+		return errBudgetExceeded
+	}
+	if totalLimit != nil && totalUsed+reserve > *totalLimit {
+		return errBudgetExceeded
+	}
+	return nil
+}
+
+// GetProjectUsage returns lifetime and per-month token usage for a project
+func (h *UsageHandler) GetProjectUsage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	projectID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	query := `
+        SELECT to_char(date_trunc('month', created_at), 'YYYY-MM'),
+               COUNT(*), SUM(prompt_tokens), SUM(completion_tokens), SUM(total_tokens), SUM(cost_usd)
+        FROM generation_runs
+        WHERE project_id = $1
+        GROUP BY 1
+        ORDER BY 1`
+
+	rows, err := h.db.Query(r.Context(), query, projectID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	total := models.UsageTotals{ProjectID: projectID}
+	months := []models.UsageTotals{}
+	for rows.Next() {
+		var month models.UsageTotals
+		err := rows.Scan(
+			&month.Month,
+			&month.Runs,
+			&month.PromptTokens,
+			&month.CompletionTokens,
+			&month.TotalTokens,
+			&month.CostUSD,
+		)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		total.Runs += month.Runs
+		total.PromptTokens += month.PromptTokens
+		total.CompletionTokens += month.CompletionTokens
+		total.TotalTokens += month.TotalTokens
+		total.CostUSD += month.CostUSD
+		months = append(months, month)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	budget, err := h.loadBudget(r.Context(), projectID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Total  models.UsageTotals    `json:"total"`
+		Months []models.UsageTotals  `json:"months"`
+		Budget *models.ProjectBudget `json:"budget,omitempty"`
+	}{total, months, budget})
+}
+
+// GetMonthlyUsage returns token usage per project for a month given as
+// ?month=YYYY-MM, defaulting to the current month
+func (h *UsageHandler) GetMonthlyUsage(w http.ResponseWriter, r *http.Request) {
+	month := r.URL.Query().Get("month")
+	if month == "" {
+		month = time.Now().Format("2006-01")
+	}
+	start, err := time.Parse("2006-01", month)
+	if err != nil {
+		http.Error(w, "Invalid month, expected YYYY-MM", http.StatusBadRequest)
+		return
+	}
+
+	query := `
+        SELECT project_id, COUNT(*), SUM(prompt_tokens), SUM(completion_tokens), SUM(total_tokens), SUM(cost_usd)
+        FROM generation_runs
+        WHERE created_at >= $1 AND created_at < $2
+        GROUP BY project_id
+        ORDER BY project_id`
+
+	rows, err := h.db.Query(r.Context(), query, start, start.AddDate(0, 1, 0))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	usage := []models.UsageTotals{}
+	for rows.Next() {
+		u := models.UsageTotals{Month: month}
+		err := rows.Scan(
+			&u.ProjectID,
+			&u.Runs,
+			&u.PromptTokens,
+			&u.CompletionTokens,
+			&u.TotalTokens,
+			&u.CostUSD,
+		)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		usage = append(usage, u)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usage)
+}
+
+// GetGenerationRuns lists the generation runs of a project, newest first,
+// paged with ?limit= and ?offset=
+func (h *UsageHandler) GetGenerationRuns(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	projectID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+	limit, offset, ok := pageParams(w, r, defaultRunLimit, maxRunLimit)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	var exists bool
+	if err := h.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM projects WHERE id = $1)`, projectID).Scan(&exists); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	query := `
+        SELECT id, project_id, COALESCE(requirements, ''), planner_backend, prompt_version, status,
+               prompt_tokens, completion_tokens, total_tokens, tokens_estimated, cost_usd,
+               COALESCE(error, ''), redactions, created_at, completed_at
+        FROM generation_runs
+        WHERE project_id = $1
+        ORDER BY created_at DESC, id DESC
+        LIMIT $2 OFFSET $3`
+
+	rows, err := h.db.Query(ctx, query, projectID, limit, offset)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	runs := []models.GenerationRun{}
+	for rows.Next() {
+		var run models.GenerationRun
+		err := rows.Scan(
+			&run.ID,
+			&run.ProjectID,
+			&run.Requirements,
+			&run.PlannerBackend,
+			&run.PromptVersion,
+			&run.Status,
+			&run.PromptTokens,
+			&run.CompletionTokens,
+			&run.TotalTokens,
+			&run.TokensEstimated,
+			&run.CostUSD,
+			&run.Error,
+			&run.Redactions,
+			&run.CreatedAt,
+			&run.CompletedAt,
+		)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		runs = append(runs, run)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(runs)
+}
+
+// GetProjectBudget returns the token budget of a project
+func (h *UsageHandler) GetProjectBudget(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	projectID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	budget, err := h.loadBudget(r.Context(), projectID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if budget == nil {
+		http.Error(w, "Budget not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(budget)
+}
+
+// SetProjectBudget creates or replaces the token budget of a project. Omitted
+// or null limits mean unlimited.
+func (h *UsageHandler) SetProjectBudget(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	projectID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	if !authorize(w, r, h.policy, auth.ManageBudget, projectID) {
+		return
+	}
+
+	var budget models.ProjectBudget
+	if err := json.NewDecoder(r.Body).Decode(&budget); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if (budget.MonthlyTokenLimit != nil && *budget.MonthlyTokenLimit < 0) ||
+		(budget.TotalTokenLimit != nil && *budget.TotalTokenLimit < 0) {
+		http.Error(w, "Token limits must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	query := `
+        INSERT INTO project_budgets (project_id, monthly_token_limit, total_token_limit)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (project_id) DO UPDATE
+        SET monthly_token_limit = EXCLUDED.monthly_token_limit,
+            total_token_limit = EXCLUDED.total_token_limit,
+            updated_at = CURRENT_TIMESTAMP
+        RETURNING project_id, monthly_token_limit, total_token_limit, updated_at`
+
+	err = h.db.QueryRow(r.Context(), query,
+		projectID,
+		budget.MonthlyTokenLimit,
+		budget.TotalTokenLimit,
+	).Scan(&budget.ProjectID, &budget.MonthlyTokenLimit, &budget.TotalTokenLimit, &budget.UpdatedAt)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(budget)
+}
+
+func (h *UsageHandler) loadBudget(ctx context.Context, projectID int) (*models.ProjectBudget, error) {
+	query := `
+        SELECT project_id, monthly_token_limit, total_token_limit, updated_at
+        FROM project_budgets
+        WHERE project_id = $1`
+
+	var budget models.ProjectBudget
+	err := h.db.QueryRow(ctx, query, projectID).Scan(
+		&budget.ProjectID,
+		&budget.MonthlyTokenLimit,
+		&budget.TotalTokenLimit,
+		&budget.UpdatedAt,
+	)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &budget, nil
+}
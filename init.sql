@@ -1,5 +1,22 @@
--- Drop existing tables if they exist
+-- Drop existing tables if they exist, each before the tables it references
+DROP TABLE IF EXISTS time_entries;
+DROP TABLE IF EXISTS attachments;
+DROP TABLE IF EXISTS project_labels;
+DROP TABLE IF EXISTS task_labels;
+DROP TABLE IF EXISTS labels;
+DROP TABLE IF EXISTS task_comment_mentions;
+DROP TABLE IF EXISTS task_comment_revisions;
+DROP TABLE IF EXISTS task_comments;
+DROP TABLE IF EXISTS task_dependencies;
+DROP TABLE IF EXISTS audit_events;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS task_feedback;
 DROP TABLE IF EXISTS tasks;
+DROP TABLE IF EXISTS generation_runs;
+DROP TABLE IF EXISTS project_budgets;
+DROP TABLE IF EXISTS sprints;
+DROP TABLE IF EXISTS milestones;
+DROP TABLE IF EXISTS employee_projects;
 DROP TABLE IF EXISTS projects;
 DROP TABLE IF EXISTS employees;
 
@@ -42,6 +59,281 @@ CREATE INDEX idx_projects_lead ON projects(lead_id);
 CREATE TABLE employee_projects (
     employee_id INTEGER REFERENCES employees(id) ON DELETE CASCADE,
     project_id INTEGER REFERENCES projects(id) ON DELETE CASCADE,
+    role VARCHAR(20) NOT NULL DEFAULT 'CONTRIBUTOR' CHECK (role IN ('LEAD', 'CONTRIBUTOR', 'REVIEWER', 'OBSERVER')),
+    allocation_percent INTEGER NOT NULL DEFAULT 100 CHECK (allocation_percent BETWEEN 0 AND 100),
+    start_date DATE,
+    end_date DATE,
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-    PRIMARY KEY (employee_id, project_id)
-);
\ No newline at end of file
+    PRIMARY KEY (employee_id, project_id),
+    CHECK (start_date IS NULL OR end_date IS NULL OR end_date >= start_date)
+);
+
+-- Create generation_runs table
+CREATE TABLE generation_runs (
+    id SERIAL PRIMARY KEY,
+    project_id INTEGER REFERENCES projects(id) ON DELETE CASCADE,
+    requirements TEXT,
+    planner_backend VARCHAR(50) NOT NULL,
+    prompt_version VARCHAR(20) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'RUNNING' CHECK (status IN ('RUNNING', 'SUCCEEDED', 'FAILED', 'REJECTED')),
+    prompt_tokens INTEGER NOT NULL DEFAULT 0,
+    completion_tokens INTEGER NOT NULL DEFAULT 0,
+    total_tokens INTEGER NOT NULL DEFAULT 0,
+    tokens_estimated BOOLEAN NOT NULL DEFAULT FALSE,
+    cost_usd NUMERIC(12, 6) NOT NULL DEFAULT 0,
+    error TEXT,
+    redactions JSONB NOT NULL DEFAULT '[]',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_generation_runs_project ON generation_runs(project_id, created_at);
+
+-- Create project_budgets table; a NULL limit means unlimited
+CREATE TABLE project_budgets (
+    project_id INTEGER PRIMARY KEY REFERENCES projects(id) ON DELETE CASCADE,
+    monthly_token_limit INTEGER CHECK (monthly_token_limit >= 0),
+    total_token_limit INTEGER CHECK (total_token_limit >= 0),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Link tasks to the generation run that produced them. generated_assigned_to
+-- keeps the planner's original pick so reassignments can be measured.
+ALTER TABLE tasks ADD COLUMN generation_run_id INTEGER REFERENCES generation_runs(id) ON DELETE SET NULL;
+ALTER TABLE tasks ADD COLUMN generated_assigned_to INTEGER REFERENCES employees(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_tasks_generation_run ON tasks(generation_run_id);
+
+-- Create task_feedback table
+CREATE TABLE task_feedback (
+    id SERIAL PRIMARY KEY,
+    task_id INTEGER NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    generation_run_id INTEGER REFERENCES generation_runs(id) ON DELETE SET NULL,
+    employee_id INTEGER REFERENCES employees(id) ON DELETE SET NULL,
+    rating VARCHAR(20) NOT NULL CHECK (rating IN ('USEFUL', 'WRONG_ASSIGNEE', 'DUPLICATE', 'IRRELEVANT')),
+    comment TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_task_feedback_task ON task_feedback(task_id);
+CREATE INDEX idx_task_feedback_run ON task_feedback(generation_run_id);
+
+-- Credentials. password_hash is a bcrypt hash; employees without one cannot
+-- log in with a password.
+ALTER TABLE employees ADD COLUMN password_hash TEXT;
+
+-- Create api_keys table. Keys without an employee belong to service accounts.
+CREATE TABLE api_keys (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    key_prefix VARCHAR(20) NOT NULL,
+    key_hash CHAR(64) NOT NULL UNIQUE,
+    employee_id INTEGER REFERENCES employees(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Create audit_events table. entity_id is text because memberships are keyed
+-- by "employee_id:project_id".
+CREATE TABLE audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    occurred_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    actor_employee_id INTEGER REFERENCES employees(id) ON DELETE SET NULL,
+    actor VARCHAR(150) NOT NULL,
+    action VARCHAR(20) NOT NULL CHECK (action IN ('CREATE', 'UPDATE', 'DELETE', 'ASSIGN', 'UNASSIGN')),
+    entity_type VARCHAR(50) NOT NULL,
+    entity_id VARCHAR(100) NOT NULL,
+    before JSONB,
+    after JSONB,
+    diff JSONB NOT NULL DEFAULT '{}',
+    request_id VARCHAR(128)
+);
+
+CREATE INDEX idx_audit_events_entity ON audit_events(entity_type, entity_id, occurred_at);
+CREATE INDEX idx_audit_events_occurred_at ON audit_events(occurred_at);
+
+-- Planning fields on tasks
+ALTER TABLE tasks ADD COLUMN priority VARCHAR(10) NOT NULL DEFAULT 'MEDIUM' CHECK (priority IN ('LOW', 'MEDIUM', 'HIGH', 'URGENT'));
+ALTER TABLE tasks ADD COLUMN start_date DATE;
+ALTER TABLE tasks ADD COLUMN due_date DATE;
+ALTER TABLE tasks ADD COLUMN story_points INTEGER CHECK (story_points >= 0);
+ALTER TABLE tasks ADD COLUMN estimate_hours NUMERIC(8, 2) CHECK (estimate_hours >= 0);
+ALTER TABLE tasks ADD COLUMN actual_hours NUMERIC(8, 2) CHECK (actual_hours >= 0);
+ALTER TABLE tasks ADD CONSTRAINT tasks_dates_ordered CHECK (due_date IS NULL OR start_date IS NULL OR due_date >= start_date);
+
+CREATE INDEX idx_tasks_due_date ON tasks(due_date);
+
+-- Create task_dependencies table. A row means task_id cannot start before
+-- depends_on_id is done; cycles are rejected by the API.
+CREATE TABLE task_dependencies (
+    task_id INTEGER NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    depends_on_id INTEGER NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (task_id, depends_on_id),
+    CHECK (task_id <> depends_on_id)
+);
+
+CREATE INDEX idx_task_dependencies_depends_on ON task_dependencies(depends_on_id);
+
+-- Status of a BLOCKED task from before it was blocked, restored when its
+-- prerequisites are done.
+ALTER TABLE tasks ADD COLUMN status_before_blocked VARCHAR(50);
+
+-- Subtasks. Deleting a parent promotes its subtasks to top-level tasks.
+ALTER TABLE tasks ADD COLUMN parent_task_id INTEGER REFERENCES tasks(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_tasks_parent ON tasks(parent_task_id);
+
+-- Create task_comments table. author is kept for service accounts and for
+-- employees that have been deleted since. Comments with replies are only
+-- marked deleted so the thread stays intact.
+CREATE TABLE task_comments (
+    id SERIAL PRIMARY KEY,
+    task_id INTEGER NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    parent_comment_id INTEGER REFERENCES task_comments(id) ON DELETE CASCADE,
+    author_employee_id INTEGER REFERENCES employees(id) ON DELETE SET NULL,
+    author VARCHAR(150) NOT NULL,
+    body TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE,
+    deleted_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_task_comments_task ON task_comments(task_id, created_at);
+CREATE INDEX idx_task_comments_parent ON task_comments(parent_comment_id);
+
+-- Earlier bodies of edited comments
+CREATE TABLE task_comment_revisions (
+    id SERIAL PRIMARY KEY,
+    comment_id INTEGER NOT NULL REFERENCES task_comments(id) ON DELETE CASCADE,
+    body TEXT NOT NULL,
+    edited_by VARCHAR(150) NOT NULL,
+    edited_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_task_comment_revisions_comment ON task_comment_revisions(comment_id, edited_at);
+
+CREATE TABLE task_comment_mentions (
+    comment_id INTEGER NOT NULL REFERENCES task_comments(id) ON DELETE CASCADE,
+    employee_id INTEGER NOT NULL REFERENCES employees(id) ON DELETE CASCADE,
+    PRIMARY KEY (comment_id, employee_id)
+);
+
+CREATE INDEX idx_task_comment_mentions_employee ON task_comment_mentions(employee_id);
+
+-- Create labels table. Labels without a project are global. Names are unique
+-- within their scope, ignoring case.
+CREATE TABLE labels (
+    id SERIAL PRIMARY KEY,
+    project_id INTEGER REFERENCES projects(id) ON DELETE CASCADE,
+    name VARCHAR(50) NOT NULL,
+    color CHAR(7) NOT NULL DEFAULT '#808080' CHECK (color ~ '^#[0-9a-f]{6}$'),
+    description TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX idx_labels_scope_name ON labels(COALESCE(project_id, 0), lower(name));
+
+CREATE TABLE task_labels (
+    task_id INTEGER NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    label_id INTEGER NOT NULL REFERENCES labels(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (task_id, label_id)
+);
+
+CREATE INDEX idx_task_labels_label ON task_labels(label_id);
+
+CREATE TABLE project_labels (
+    project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    label_id INTEGER NOT NULL REFERENCES labels(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (project_id, label_id)
+);
+
+CREATE INDEX idx_project_labels_label ON project_labels(label_id);
+
+-- Create attachments table. The content lives in the blob store under
+-- storage_key; sha256 is the hex digest of the content.
+CREATE TABLE attachments (
+    id SERIAL PRIMARY KEY,
+    task_id INTEGER REFERENCES tasks(id) ON DELETE CASCADE,
+    project_id INTEGER REFERENCES projects(id) ON DELETE CASCADE,
+    file_name VARCHAR(255) NOT NULL,
+    content_type VARCHAR(100) NOT NULL,
+    size_bytes BIGINT NOT NULL CHECK (size_bytes >= 0),
+    sha256 CHAR(64) NOT NULL,
+    storage_key VARCHAR(255) NOT NULL UNIQUE,
+    uploaded_by_employee_id INTEGER REFERENCES employees(id) ON DELETE SET NULL,
+    uploaded_by VARCHAR(150) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CHECK ((task_id IS NULL) <> (project_id IS NULL))
+);
+
+CREATE INDEX idx_attachments_task ON attachments(task_id);
+CREATE INDEX idx_attachments_project ON attachments(project_id);
+
+-- Create time_entries table. An entry without ended_at is a running timer.
+-- An employee's entries never overlap and at most one timer runs at a time.
+CREATE EXTENSION IF NOT EXISTS btree_gist;
+
+CREATE TABLE time_entries (
+    id SERIAL PRIMARY KEY,
+    task_id INTEGER NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    employee_id INTEGER NOT NULL REFERENCES employees(id) ON DELETE CASCADE,
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    ended_at TIMESTAMP WITH TIME ZONE,
+    note TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CHECK (ended_at IS NULL OR ended_at > started_at),
+    EXCLUDE USING gist (employee_id WITH =, tstzrange(started_at, ended_at) WITH &&)
+);
+
+CREATE UNIQUE INDEX idx_time_entries_running ON time_entries(employee_id) WHERE ended_at IS NULL;
+CREATE INDEX idx_time_entries_task ON time_entries(task_id);
+CREATE INDEX idx_time_entries_employee_started ON time_entries(employee_id, started_at);
+
+-- Create sprints table. A project has at most one ACTIVE sprint; tasks
+-- without a sprint are in the project's backlog.
+CREATE TABLE sprints (
+    id SERIAL PRIMARY KEY,
+    project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    goal TEXT,
+    status VARCHAR(20) NOT NULL DEFAULT 'PLANNED' CHECK (status IN ('PLANNED', 'ACTIVE', 'CLOSED')),
+    start_date DATE NOT NULL,
+    end_date DATE NOT NULL,
+    started_at TIMESTAMP WITH TIME ZONE,
+    closed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CHECK (end_date >= start_date)
+);
+
+CREATE INDEX idx_sprints_project ON sprints(project_id, start_date);
+CREATE UNIQUE INDEX idx_sprints_active ON sprints(project_id) WHERE status = 'ACTIVE';
+
+ALTER TABLE tasks ADD COLUMN sprint_id INTEGER REFERENCES sprints(id) ON DELETE SET NULL;
+CREATE INDEX idx_tasks_sprint ON tasks(sprint_id);
+
+-- Counts of a sprint saved when it is closed, before its unfinished tasks
+-- are carried over. Open sprints count their current tasks.
+ALTER TABLE sprints ADD COLUMN closed_tasks INTEGER;
+ALTER TABLE sprints ADD COLUMN closed_done_tasks INTEGER;
+ALTER TABLE sprints ADD COLUMN closed_story_points INTEGER;
+ALTER TABLE sprints ADD COLUMN closed_completed_story_points INTEGER;
+
+-- Create milestones table. Progress and risk are derived from the linked tasks.
+CREATE TABLE milestones (
+    id SERIAL PRIMARY KEY,
+    project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    description TEXT,
+    target_date DATE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX idx_milestones_project_name ON milestones(project_id, lower(name));
+
+ALTER TABLE tasks ADD COLUMN milestone_id INTEGER REFERENCES milestones(id) ON DELETE SET NULL;
+CREATE INDEX idx_tasks_milestone ON tasks(milestone_id);
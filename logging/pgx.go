@@ -0,0 +1,69 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// QueryTracer logs every query at debug level and failed queries at error
+// level, tagged with the request ID of the context the query ran under.
+type QueryTracer struct {
+	logger *slog.Logger
+}
+
+func NewQueryTracer(logger *slog.Logger) *QueryTracer {
+	return &QueryTracer{logger: logger}
+}
+
+type queryStartKey struct{}
+
+type queryStart struct {
+	sql  string
+	args int
+	at   time.Time
+}
+
+func (t *QueryTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, queryStartKey{}, queryStart{sql: data.SQL, args: len(data.Args), at: time.Now()})
+}
+
+func (t *QueryTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	start, _ := ctx.Value(queryStartKey{}).(queryStart)
+	attrs := []slog.Attr{
+		slog.String("sql", compactSQL(start.sql)),
+		slog.Int("args", start.args),
+		slog.Duration("duration", time.Since(start.at)),
+		slog.Int64("rows", data.CommandTag.RowsAffected()),
+	}
+
+	// No rows is how lookups report "not found"; handlers turn it into a 404
+	if data.Err != nil && !errors.Is(data.Err, pgx.ErrNoRows) {
+		t.logger.LogAttrs(ctx, slog.LevelError, "query failed", append(attrs, slog.String("error", data.Err.Error()))...)
+		return
+	}
+	t.logger.LogAttrs(ctx, slog.LevelDebug, "query", attrs...)
+}
+
+// compactSQL collapses the indentation of the multi-line queries used in the
+// handlers so each query logs on one line.
+func compactSQL(sql string) string {
+	out := make([]byte, 0, len(sql))
+	space := false
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		if c == ' ' || c == '\n' || c == '\t' || c == '\r' {
+			space = len(out) > 0
+			continue
+		}
+		if space {
+			out = append(out, ' ')
+			space = false
+		}
+		out = append(out, c)
+	}
+	return string(out)
+}
@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// scrapeTimeout bounds the queries run while serving /metrics.
+const scrapeTimeout = 5 * time.Second
+
+// PoolCollector exports the statistics of a pgx connection pool.
+type PoolCollector struct {
+	pool *pgxpool.Pool
+
+	acquiredConns        *prometheus.Desc
+	idleConns            *prometheus.Desc
+	totalConns           *prometheus.Desc
+	maxConns             *prometheus.Desc
+	acquireCount         *prometheus.Desc
+	acquireDuration      *prometheus.Desc
+	emptyAcquireCount    *prometheus.Desc
+	canceledAcquireCount *prometheus.Desc
+}
+
+func NewPoolCollector(pool *pgxpool.Pool) *PoolCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "db_pool", name), help, nil, nil)
+	}
+	return &PoolCollector{
+		pool:                 pool,
+		acquiredConns:        desc("acquired_conns", "Connections currently in use."),
+		idleConns:            desc("idle_conns", "Idle connections in the pool."),
+		totalConns:           desc("total_conns", "Open connections in the pool."),
+		maxConns:             desc("max_conns", "Maximum size of the pool."),
+		acquireCount:         desc("acquires_total", "Successful connection acquisitions."),
+		acquireDuration:      desc("acquire_duration_seconds_total", "Time spent acquiring connections."),
+		emptyAcquireCount:    desc("empty_acquires_total", "Acquisitions that had to wait because the pool was empty."),
+		canceledAcquireCount: desc("canceled_acquires_total", "Acquisitions canceled by their context."),
+	}
+}
+
+func (c *PoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c *PoolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.pool.Stat()
+	ch <- prometheus.MustNewConstMetric(c.acquiredConns, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(c.idleConns, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(c.totalConns, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(c.maxConns, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(c.acquireCount, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.acquireDuration, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+	ch <- prometheus.MustNewConstMetric(c.emptyAcquireCount, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.canceledAcquireCount, prometheus.CounterValue, float64(stat.CanceledAcquireCount()))
+}
+
+// DBCollector exports gauges read from the database on every scrape: task
+// counts by status and generation runs still marked RUNNING.
+type DBCollector struct {
+	db *pgxpool.Pool
+
+	tasks           *prometheus.Desc
+	generationQueue *prometheus.Desc
+}
+
+func NewDBCollector(db *pgxpool.Pool) *DBCollector {
+	return &DBCollector{
+		db: db,
+		tasks: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "tasks"),
+			"Tasks by status.", []string{"status"}, nil),
+		generationQueue: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "generation_runs_running"),
+			"Generation runs recorded as RUNNING across all instances.", nil, nil),
+	}
+}
+
+func (c *DBCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c *DBCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), scrapeTimeout)
+	defer cancel()
+
+	rows, err := c.db.Query(ctx, `SELECT status, COUNT(*) FROM tasks GROUP BY status`)
+	if err != nil {
+		slog.ErrorContext(ctx, "metrics: failed to count tasks", "error", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var count int64
+		if err := rows.Scan(&status, &count); err != nil {
+			slog.ErrorContext(ctx, "metrics: failed to count tasks", "error", err)
+			return
+		}
+		ch <- prometheus.MustNewConstMetric(c.tasks, prometheus.GaugeValue, float64(count), status)
+	}
+	rows.Close()
+
+	var running int64
+	err = c.db.QueryRow(ctx, `SELECT COUNT(*) FROM generation_runs WHERE status = 'RUNNING'`).Scan(&running)
+	if err != nil {
+		slog.ErrorContext(ctx, "metrics: failed to count generation runs", "error", err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.generationQueue, prometheus.GaugeValue, float64(running))
+}
@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "main_backend"
+
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route template and status code.",
+	}, []string{"method", "route", "status"})
+
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method and route template.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	PlannerCallDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "planner_call_duration_seconds",
+		Help:      "Duration of calls to the planner service.",
+		// Generations take tens of seconds, far beyond the default buckets
+		Buckets: []float64{0.5, 1, 2.5, 5, 10, 20, 30, 60, 120, 300},
+	}, []string{"backend"})
+
+	PlannerCallFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "planner_call_failures_total",
+		Help:      "Planner calls that failed or returned a non-200 status.",
+	}, []string{"backend"})
+
+	GenerationsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "generation_jobs_in_flight",
+		Help:      "Task generations currently waiting on the planner.",
+	})
+)
@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// corsMethods are the methods probed against the router to work out which
+// ones a path supports.
+var corsMethods = []string{
+	http.MethodGet,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+}
+
+type CORSPolicy struct {
+	// AllowedOrigins lists exact origins such as "http://localhost:3000".
+	// "*" allows any origin but disables credentials.
+	AllowedOrigins   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// CORS applies policy to cross-origin requests. Preflight requests are
+// answered directly, advertising only the methods router has routes for on
+// the requested path; they never reach authentication.
+func CORS(policy CORSPolicy, router *mux.Router) func(http.Handler) http.Handler {
+	anyOrigin := slices.Contains(policy.AllowedOrigins, "*")
+	allowedHeaders := make(map[string]bool)
+	for _, header := range policy.AllowedHeaders {
+		allowedHeaders[http.CanonicalHeaderKey(header)] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+			// The response differs per origin, so caches must key on it
+			w.Header().Add("Vary", "Origin")
+			if preflight {
+				w.Header().Add("Vary", "Access-Control-Request-Method")
+				w.Header().Add("Vary", "Access-Control-Request-Headers")
+			}
+
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			allowed := anyOrigin || slices.Contains(policy.AllowedOrigins, origin)
+			if !allowed {
+				if preflight {
+					http.Error(w, "Origin not allowed", http.StatusForbidden)
+					return
+				}
+				// Without CORS headers the browser withholds the response
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if anyOrigin {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				if policy.AllowCredentials {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
+			}
+
+			if !preflight {
+				if len(policy.ExposedHeaders) > 0 {
+					w.Header().Set("Access-Control-Expose-Headers", strings.Join(policy.ExposedHeaders, ", "))
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			methods := routeMethods(router, r)
+			if len(methods) == 0 {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(append(methods, http.MethodOptions), ", "))
+
+			var headers []string
+			for _, header := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+				header = http.CanonicalHeaderKey(strings.TrimSpace(header))
+				if header != "" && allowedHeaders[header] {
+					headers = append(headers, header)
+				}
+			}
+			if len(headers) > 0 {
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+			}
+
+			if policy.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(policy.MaxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// routeMethods returns the methods router has a route for on r's path.
+func routeMethods(router *mux.Router, r *http.Request) []string {
+	var methods []string
+	for _, method := range corsMethods {
+		probe := r.Clone(r.Context())
+		probe.Method = method
+		var match mux.RouteMatch
+		if router.Match(probe, &match) && match.MatchErr == nil {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
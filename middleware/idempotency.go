@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"nstorm.com/main-backend/auth"
+	"nstorm.com/main-backend/cache"
+)
+
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotentBody bounds the request body buffered to fingerprint a key.
+const maxIdempotentBody = 1 << 20
+
+// Idempotency makes POST requests carrying an Idempotency-Key header safe to
+// retry. A repeated key replays the stored response, a request arriving while
+// the first one is still running waits for it, and reusing a key with a
+// different body is rejected. Multipart uploads are passed through without
+// it, since their body would have to be buffered whole.
+func Idempotency(store *cache.Store) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if r.Method != http.MethodPost || key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > 255 {
+				http.Error(w, "Idempotency-Key must be at most 255 characters", http.StatusBadRequest)
+				return
+			}
+
+			if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBody))
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, fmt.Sprintf("Requests with an Idempotency-Key are limited to %d bytes", maxIdempotentBody), http.StatusRequestEntityTooLarge)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			sum := sha256.Sum256(body)
+
+			// Keys are scoped to the caller so one client cannot replay another's response
+			scope := auth.FromContext(r.Context()).String() + "\x00" + r.URL.Path + "\x00" + key
+			resp, outcome, err := store.Do(scope, hex.EncodeToString(sum[:]), func(w http.ResponseWriter) {
+				next.ServeHTTP(w, r)
+			})
+			if err == cache.ErrFingerprintMismatch {
+				http.Error(w, "Idempotency-Key was already used with a different request body", http.StatusUnprocessableEntity)
+				return
+			}
+
+			if outcome != cache.Miss {
+				w.Header().Set("Idempotent-Replayed", "true")
+			}
+			resp.WriteTo(w)
+		})
+	}
+}
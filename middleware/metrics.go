@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"nstorm.com/main-backend/metrics"
+)
+
+// Metrics counts requests and observes their latency by route template.
+// Requests that match no route share the "unmatched" label so that scanners
+// cannot blow up the number of series.
+func Metrics(router *mux.Router) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rw := &responseWriter{ResponseWriter: w}
+			next.ServeHTTP(rw, r)
+
+			route := routeTemplate(router, r)
+			if route == "" {
+				route = "unmatched"
+			}
+			status := rw.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			metrics.HTTPRequests.WithLabelValues(r.Method, route, strconv.Itoa(status)).Inc()
+			metrics.HTTPRequestDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"nstorm.com/main-backend/auth"
+	"nstorm.com/main-backend/ratelimit"
+)
+
+// RateLimits maps routes to limits. Routes are keyed by method and path
+// template, e.g. "POST /projects/{id}/generate-tasks", and get a bucket of
+// their own; every other route shares the Default bucket.
+type RateLimits struct {
+	Default ratelimit.Limit
+	Routes  map[string]ratelimit.Limit
+}
+
+// RateLimit applies token-bucket limits per caller. Callers are identified by
+// API key, then employee, then client IP, so it must run after Authenticate
+// for authenticated routes. Store errors let the request through.
+func RateLimit(store ratelimit.Store, limits RateLimits) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			bucket, limit := "default", limits.Default
+			if route := routeKey(r); route != "" {
+				if routeLimit, ok := limits.Routes[route]; ok {
+					bucket, limit = route, routeLimit
+				}
+			}
+			if !limit.Enabled() {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := bucket + "\x00" + rateLimitSubject(r)
+			result, err := store.Take(r.Context(), key, limit)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "rate limit store failed", "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Burst, seconds(limit.Period)))
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(seconds(result.Reset)))
+
+			if !result.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(seconds(result.RetryAfter)))
+				http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// IPRateLimit applies one token-bucket limit per client IP. It runs before
+// Authenticate so that requests with invalid credentials, which never reach
+// RateLimit, are limited too. Store errors let the request through.
+func IPRateLimit(store ratelimit.Store, limit ratelimit.Limit) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !limit.Enabled() {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			result, err := store.Take(r.Context(), "ip\x00"+clientIP(r), limit)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "rate limit store failed", "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !result.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(seconds(result.RetryAfter)))
+				http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// routeKey returns "METHOD /path/{template}" for the matched mux route.
+func routeKey(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return ""
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return ""
+	}
+	return r.Method + " " + template
+}
+
+func rateLimitSubject(r *http.Request) string {
+	if principal := auth.FromContext(r.Context()); principal != nil {
+		if principal.APIKeyID != 0 {
+			return fmt.Sprintf("api-key:%d", principal.APIKeyID)
+		}
+		return principal.String()
+	}
+	return "ip:" + clientIP(r)
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// seconds rounds d up so clients never retry too early.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing starts a server span per request, continuing any trace context the
+// caller sent. Spans are named by method and route template.
+func Tracing(router *mux.Router) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		tagged := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if route := routeTemplate(router, r); route != "" {
+				trace.SpanFromContext(r.Context()).SetAttributes(semconv.HTTPRoute(route))
+			}
+			next.ServeHTTP(w, r)
+		})
+		return otelhttp.NewHandler(tagged, "http.request",
+			otelhttp.WithSpanNameFormatter(func(operation string, r *http.Request) string {
+				if route := routeTemplate(router, r); route != "" {
+					return r.Method + " " + route
+				}
+				return r.Method
+			}),
+		)
+	}
+}
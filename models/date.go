@@ -0,0 +1,65 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const DateLayout = "2006-01-02"
+
+// Date is a calendar date without a time of day. It is encoded as
+// "YYYY-MM-DD" in JSON and maps to a Postgres DATE column.
+type Date struct {
+	time.Time
+}
+
+func NewDate(year int, month time.Month, day int) Date {
+	return Date{time.Date(year, month, day, 0, 0, 0, 0, time.UTC)}
+}
+
+// ParseDate parses a "YYYY-MM-DD" string.
+func ParseDate(value string) (Date, error) {
+	t, err := time.Parse(DateLayout, value)
+	if err != nil {
+		return Date{}, err
+	}
+	return Date{t}, nil
+}
+
+// Today returns the current date in UTC.
+func Today() Date {
+	now := time.Now().UTC()
+	return NewDate(now.Year(), now.Month(), now.Day())
+}
+
+func (d Date) String() string {
+	return d.Format(DateLayout)
+}
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Date) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	parsed, err := ParseDate(value)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+func (d *Date) ScanDate(v pgtype.Date) error {
+	*d = Date{v.Time}
+	return nil
+}
+
+func (d Date) DateValue() (pgtype.Date, error) {
+	return pgtype.Date{Time: d.Time, Valid: true}, nil
+}
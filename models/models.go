@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type EmployeeRole string
 
@@ -9,6 +12,33 @@ const (
 	RoleDeveloper      EmployeeRole = "DEVELOPER"
 )
 
+const (
+	TaskStatusTodo       = "TODO"
+	TaskStatusInProgress = "IN_PROGRESS"
+	TaskStatusDone       = "DONE"
+	// TaskStatusBlocked is set automatically while a task has prerequisites
+	// that are not done, and cleared back to the previous status once they
+	// all are. It cannot be set by hand.
+	TaskStatusBlocked = "BLOCKED"
+)
+
+type TaskPriority string
+
+const (
+	PriorityLow    TaskPriority = "LOW"
+	PriorityMedium TaskPriority = "MEDIUM"
+	PriorityHigh   TaskPriority = "HIGH"
+	PriorityUrgent TaskPriority = "URGENT"
+)
+
+func (p TaskPriority) Valid() bool {
+	switch p {
+	case PriorityLow, PriorityMedium, PriorityHigh, PriorityUrgent:
+		return true
+	}
+	return false
+}
+
 type Employee struct {
 	ID        int          `json:"id"`
 	Name      string       `json:"name"`
@@ -18,6 +48,35 @@ type Employee struct {
 	CreatedAt time.Time    `json:"created_at"`
 	Projects  []Project    `json:"projects,omitempty"`
 	Tasks     []Task       `json:"tasks,omitempty"`
+	// Membership is set when the employee is listed as part of a project.
+	Membership *ProjectMembership `json:"membership,omitempty"`
+}
+
+type MembershipRole string
+
+const (
+	MembershipLead        MembershipRole = "LEAD"
+	MembershipContributor MembershipRole = "CONTRIBUTOR"
+	MembershipReviewer    MembershipRole = "REVIEWER"
+	MembershipObserver    MembershipRole = "OBSERVER"
+)
+
+func (r MembershipRole) Valid() bool {
+	switch r {
+	case MembershipLead, MembershipContributor, MembershipReviewer, MembershipObserver:
+		return true
+	}
+	return false
+}
+
+type ProjectMembership struct {
+	EmployeeID        int            `json:"employee_id"`
+	ProjectID         int            `json:"project_id"`
+	Role              MembershipRole `json:"role"`
+	AllocationPercent int            `json:"allocation_percent"`
+	StartDate         *Date          `json:"start_date,omitempty"`
+	EndDate           *Date          `json:"end_date,omitempty"`
+	CreatedAt         time.Time      `json:"created_at"`
 }
 
 type Project struct {
@@ -30,11 +89,400 @@ type Project struct {
 }
 
 type Task struct {
+	ID          int          `json:"id"`
+	ProjectID   int          `json:"project_id"`
+	AssignedTo  int          `json:"assigned_to"`
+	Title       string       `json:"title"`
+	Description string       `json:"description"`
+	Status      string       `json:"status"`
+	Priority    TaskPriority `json:"priority"`
+	StartDate   *Date        `json:"start_date,omitempty"`
+	DueDate     *Date        `json:"due_date,omitempty"`
+	// Estimates may be given in story points, hours or both. ActualHours
+	// is the effort reported on the task so far; TrackedHours is read-only,
+	// the total of its finished time entries.
+	StoryPoints   *int     `json:"story_points,omitempty"`
+	EstimateHours *float64 `json:"estimate_hours,omitempty"`
+	ActualHours   *float64 `json:"actual_hours,omitempty"`
+	TrackedHours  *float64 `json:"tracked_hours,omitempty"`
+	// ParentTaskID is set on subtasks. A parent belongs to the same project
+	// and cannot be done while any of its subtasks is open.
+	ParentTaskID *int `json:"parent_task_id,omitempty"`
+	// SprintID places the task in a sprint of its project; tasks without
+	// one are in the project's backlog.
+	SprintID *int `json:"sprint_id,omitempty"`
+	// MilestoneID links the task to a milestone of its project.
+	MilestoneID *int      `json:"milestone_id,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	// Labels are read-only here; they are attached through
+	// /tasks/{id}/labels.
+	Labels []Label `json:"labels,omitempty"`
+}
+
+// Label tags tasks and projects. Labels without a project are global; the
+// others can only be used within their project.
+type Label struct {
 	ID          int       `json:"id"`
-	ProjectID   int       `json:"project_id"`
-	AssignedTo  int       `json:"assigned_to"`
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	Status      string    `json:"status"`
+	ProjectID   *int      `json:"project_id,omitempty"`
+	Name        string    `json:"name"`
+	Color       string    `json:"color"`
+	Description string    `json:"description,omitempty"`
 	CreatedAt   time.Time `json:"created_at"`
 }
+
+const (
+	SprintPlanned = "PLANNED"
+	SprintActive  = "ACTIVE"
+	SprintClosed  = "CLOSED"
+)
+
+// Sprint is a time box of a project. A project has at most one ACTIVE
+// sprint; closing it carries its unfinished tasks over. The counts cover
+// the tasks currently in the sprint, or for a closed sprint those it had
+// when it was closed.
+type Sprint struct {
+	ID                   int        `json:"id"`
+	ProjectID            int        `json:"project_id"`
+	Name                 string     `json:"name"`
+	Goal                 string     `json:"goal,omitempty"`
+	Status               string     `json:"status"`
+	StartDate            Date       `json:"start_date"`
+	EndDate              Date       `json:"end_date"`
+	StartedAt            *time.Time `json:"started_at,omitempty"`
+	ClosedAt             *time.Time `json:"closed_at,omitempty"`
+	CreatedAt            time.Time  `json:"created_at"`
+	TaskCount            int        `json:"task_count"`
+	DoneTaskCount        int        `json:"done_task_count"`
+	StoryPoints          int        `json:"story_points"`
+	CompletedStoryPoints int        `json:"completed_story_points"`
+}
+
+// SprintClosure is the result of closing a sprint. CarriedOverTo is nil
+// when the unfinished tasks went back to the backlog.
+type SprintClosure struct {
+	Sprint        Sprint `json:"sprint"`
+	CarriedOver   []int  `json:"carried_over"`
+	CarriedOverTo *int   `json:"carried_over_to,omitempty"`
+}
+
+// Milestone is a target date of a project, such as a release, that tasks
+// are linked to.
+type Milestone struct {
+	ID          int               `json:"id"`
+	ProjectID   int               `json:"project_id"`
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	TargetDate  Date              `json:"target_date"`
+	CreatedAt   time.Time         `json:"created_at"`
+	Progress    MilestoneProgress `json:"progress"`
+}
+
+// MilestoneProgress is derived from the tasks of a milestone. Percent is the
+// share of them that is done. RemainingHours is the estimate not yet logged
+// on the open ones; ProjectedFinish is when the project schedule has the
+// last of them done.
+type MilestoneProgress struct {
+	Tasks            int     `json:"tasks"`
+	DoneTasks        int     `json:"done_tasks"`
+	Percent          float64 `json:"percent"`
+	RemainingHours   float64 `json:"remaining_hours"`
+	UnestimatedTasks int     `json:"unestimated_tasks"`
+	ProjectedFinish  *Date   `json:"projected_finish,omitempty"`
+	AtRisk           bool    `json:"at_risk"`
+}
+
+// TaskTree is a task with its subtasks, recursively. Rollup is only set on
+// tasks that have subtasks.
+type TaskTree struct {
+	Task
+	Rollup   *TaskRollup `json:"rollup,omitempty"`
+	Subtasks []TaskTree  `json:"subtasks"`
+}
+
+// TaskRollup summarises all subtasks of a task, at any depth. Status is DONE
+// when every subtask is done, IN_PROGRESS once any has been started or
+// finished, BLOCKED when all are blocked and TODO otherwise. Points and hours
+// add up the subtasks without subtasks of their own.
+type TaskRollup struct {
+	Status        string  `json:"status"`
+	Subtasks      int     `json:"subtasks"`
+	DoneSubtasks  int     `json:"done_subtasks"`
+	StoryPoints   int     `json:"story_points"`
+	EstimateHours float64 `json:"estimate_hours"`
+	ActualHours   float64 `json:"actual_hours"`
+}
+
+// TaskDependency records that TaskID cannot start before DependsOnID is done.
+type TaskDependency struct {
+	TaskID      int       `json:"task_id"`
+	DependsOnID int       `json:"depends_on_id"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// TaskDependencies lists the prerequisites of a task and the tasks that
+// wait on it.
+type TaskDependencies struct {
+	TaskID     int    `json:"task_id"`
+	DependsOn  []Task `json:"depends_on"`
+	Dependents []Task `json:"dependents"`
+}
+
+// DependencyGraph holds a project's tasks and the dependencies between them.
+// Edges point from the prerequisite to the task that depends on it. Tasks of
+// other projects linked to the project appear as external nodes.
+type DependencyGraph struct {
+	ProjectID int              `json:"project_id"`
+	Nodes     []DependencyNode `json:"nodes"`
+	Edges     []DependencyEdge `json:"edges"`
+}
+
+type DependencyNode struct {
+	Task
+	External bool `json:"external"`
+}
+
+type DependencyEdge struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+// ProjectSchedule is the computed timeline of a project's open tasks. Days
+// are working days, Monday to Friday.
+type ProjectSchedule struct {
+	ProjectID    int             `json:"project_id"`
+	StartDate    Date            `json:"start_date"`
+	FinishDate   *Date           `json:"finish_date,omitempty"`
+	DurationDays int             `json:"duration_days"`
+	CriticalPath []int           `json:"critical_path"`
+	Tasks        []ScheduledTask `json:"tasks"`
+	Warnings     []string        `json:"warnings"`
+}
+
+// ScheduledTask is one bar of the timeline. Done tasks carry no dates.
+type ScheduledTask struct {
+	TaskID         int          `json:"task_id"`
+	Title          string       `json:"title"`
+	AssignedTo     int          `json:"assigned_to,omitempty"`
+	Status         string       `json:"status"`
+	Priority       TaskPriority `json:"priority"`
+	DurationDays   int          `json:"duration_days"`
+	EarliestStart  *Date        `json:"earliest_start,omitempty"`
+	EarliestFinish *Date        `json:"earliest_finish,omitempty"`
+	LatestStart    *Date        `json:"latest_start,omitempty"`
+	LatestFinish   *Date        `json:"latest_finish,omitempty"`
+	SlackDays      int          `json:"slack_days"`
+	Critical       bool         `json:"critical"`
+	// Late is set when the earliest finish is after the task's due date.
+	Late bool `json:"late"`
+	// Progress is the share of the estimate already logged, in [0, 1].
+	Progress  float64 `json:"progress"`
+	DependsOn []int   `json:"depends_on"`
+}
+
+type GenerationRun struct {
+	ID               int         `json:"id"`
+	ProjectID        int         `json:"project_id"`
+	Requirements     string      `json:"requirements"`
+	PlannerBackend   string      `json:"planner_backend"`
+	PromptVersion    string      `json:"prompt_version"`
+	Status           string      `json:"status"`
+	PromptTokens     int         `json:"prompt_tokens"`
+	CompletionTokens int         `json:"completion_tokens"`
+	TotalTokens      int         `json:"total_tokens"`
+	TokensEstimated  bool        `json:"tokens_estimated"`
+	CostUSD          float64     `json:"cost_usd"`
+	Error            string      `json:"error,omitempty"`
+	Redactions       []Redaction `json:"redactions,omitempty"`
+	CreatedAt        time.Time   `json:"created_at"`
+	CompletedAt      *time.Time  `json:"completed_at,omitempty"`
+}
+
+type UsageTotals struct {
+	ProjectID        int     `json:"project_id,omitempty"`
+	Month            string  `json:"month,omitempty"`
+	Runs             int     `json:"runs"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+}
+
+type ProjectBudget struct {
+	ProjectID         int       `json:"project_id"`
+	MonthlyTokenLimit *int      `json:"monthly_token_limit"`
+	TotalTokenLimit   *int      `json:"total_token_limit"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+type Redaction struct {
+	Kind  string `json:"kind"`
+	Count int    `json:"count"`
+}
+
+type FeedbackRating string
+
+const (
+	RatingUseful        FeedbackRating = "USEFUL"
+	RatingWrongAssignee FeedbackRating = "WRONG_ASSIGNEE"
+	RatingDuplicate     FeedbackRating = "DUPLICATE"
+	RatingIrrelevant    FeedbackRating = "IRRELEVANT"
+)
+
+func (r FeedbackRating) Valid() bool {
+	switch r {
+	case RatingUseful, RatingWrongAssignee, RatingDuplicate, RatingIrrelevant:
+		return true
+	}
+	return false
+}
+
+type TaskFeedback struct {
+	ID              int            `json:"id"`
+	TaskID          int            `json:"task_id"`
+	GenerationRunID int            `json:"generation_run_id"`
+	EmployeeID      *int           `json:"employee_id,omitempty"`
+	Rating          FeedbackRating `json:"rating"`
+	Comment         string         `json:"comment,omitempty"`
+	CreatedAt       time.Time      `json:"created_at"`
+}
+
+// PlannerEvaluation aggregates feedback and outcomes of generated tasks for
+// one planner backend and prompt version. Rates are fractions in [0, 1].
+type PlannerEvaluation struct {
+	PlannerBackend   string                 `json:"planner_backend"`
+	PromptVersion    string                 `json:"prompt_version"`
+	Runs             int                    `json:"runs"`
+	GeneratedTasks   int                    `json:"generated_tasks"`
+	RatedTasks       int                    `json:"rated_tasks"`
+	Ratings          map[FeedbackRating]int `json:"ratings"`
+	AcceptanceRate   float64                `json:"acceptance_rate"`
+	ReassignmentRate float64                `json:"reassignment_rate"`
+	CompletionRate   float64                `json:"completion_rate"`
+}
+
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	EmployeeID *int       `json:"employee_id,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	// Key is only populated in the response that creates the key.
+	Key string `json:"key,omitempty"`
+}
+
+// TaskComment is a markdown comment on a task. Replies set ParentCommentID;
+// listings nest every reply of a thread under its top-level comment.
+type TaskComment struct {
+	ID               int              `json:"id"`
+	TaskID           int              `json:"task_id"`
+	ParentCommentID  *int             `json:"parent_comment_id,omitempty"`
+	AuthorEmployeeID *int             `json:"author_employee_id,omitempty"`
+	Author           string           `json:"author"`
+	Body             string           `json:"body"`
+	Mentions         []CommentMention `json:"mentions"`
+	// Deleted comments keep their place in a thread that has replies, with
+	// the body removed.
+	Deleted   bool          `json:"deleted,omitempty"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt *time.Time    `json:"updated_at,omitempty"`
+	Replies   []TaskComment `json:"replies,omitempty"`
+}
+
+// CommentMention is an employee @mentioned in a comment.
+type CommentMention struct {
+	EmployeeID int    `json:"employee_id"`
+	Name       string `json:"name"`
+}
+
+// CommentRevision is an earlier body of an edited comment.
+type CommentRevision struct {
+	ID        int       `json:"id"`
+	CommentID int       `json:"comment_id"`
+	Body      string    `json:"body"`
+	EditedBy  string    `json:"edited_by"`
+	EditedAt  time.Time `json:"edited_at"`
+}
+
+// Attachment is a file uploaded to a task or a project. Exactly one of
+// TaskID and ProjectID is set. The content is served from
+// /attachments/{id}/content.
+type Attachment struct {
+	ID                   int       `json:"id"`
+	TaskID               *int      `json:"task_id,omitempty"`
+	ProjectID            *int      `json:"project_id,omitempty"`
+	FileName             string    `json:"file_name"`
+	ContentType          string    `json:"content_type"`
+	SizeBytes            int64     `json:"size_bytes"`
+	SHA256               string    `json:"sha256"`
+	UploadedByEmployeeID *int      `json:"uploaded_by_employee_id,omitempty"`
+	UploadedBy           string    `json:"uploaded_by"`
+	CreatedAt            time.Time `json:"created_at"`
+}
+
+// TimeEntry is time an employee spent on a task. EndedAt is nil while the
+// entry is a running timer; Hours then counts up to now.
+type TimeEntry struct {
+	ID         int        `json:"id"`
+	TaskID     int        `json:"task_id"`
+	EmployeeID int        `json:"employee_id"`
+	StartedAt  time.Time  `json:"started_at"`
+	EndedAt    *time.Time `json:"ended_at,omitempty"`
+	Hours      float64    `json:"hours"`
+	Note       string     `json:"note,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// WeeklyTimesheet is an employee's tracked time for one ISO week, one row
+// per task with the hours of each day from Monday to Sunday.
+type WeeklyTimesheet struct {
+	EmployeeID  int            `json:"employee_id"`
+	Week        string         `json:"week"`
+	Days        []Date         `json:"days"`
+	Rows        []TimesheetRow `json:"rows"`
+	DailyTotals []float64      `json:"daily_totals"`
+	TotalHours  float64        `json:"total_hours"`
+}
+
+type TimesheetRow struct {
+	TaskID      int       `json:"task_id"`
+	TaskTitle   string    `json:"task_title"`
+	ProjectID   int       `json:"project_id"`
+	ProjectName string    `json:"project_name"`
+	Hours       []float64 `json:"hours"`
+	TotalHours  float64   `json:"total_hours"`
+}
+
+// ProjectTimesheet is the time tracked on a project's tasks, per employee
+// and ISO week.
+type ProjectTimesheet struct {
+	ProjectID  int                   `json:"project_id"`
+	From       Date                  `json:"from"`
+	To         Date                  `json:"to"`
+	Rows       []ProjectTimesheetRow `json:"rows"`
+	TotalHours float64               `json:"total_hours"`
+}
+
+type ProjectTimesheetRow struct {
+	EmployeeID   int     `json:"employee_id"`
+	EmployeeName string  `json:"employee_name"`
+	Week         string  `json:"week"`
+	WeekStart    Date    `json:"week_start"`
+	Hours        float64 `json:"hours"`
+}
+
+type AuditEvent struct {
+	ID              int64           `json:"id"`
+	OccurredAt      time.Time       `json:"occurred_at"`
+	ActorEmployeeID *int            `json:"actor_employee_id,omitempty"`
+	Actor           string          `json:"actor"`
+	Action          string          `json:"action"`
+	EntityType      string          `json:"entity_type"`
+	EntityID        string          `json:"entity_id"`
+	Before          json.RawMessage `json:"before,omitempty"`
+	After           json.RawMessage `json:"after,omitempty"`
+	Diff            json.RawMessage `json:"diff"`
+	RequestID       string          `json:"request_id,omitempty"`
+}
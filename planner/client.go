@@ -0,0 +1,169 @@
+package planner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"nstorm.com/main-backend/metrics"
+	"nstorm.com/main-backend/middleware"
+)
+
+// PromptVersion identifies the shape of the prompt built by BuildPrompt. It is
+// recorded on every generation run so planner quality can be compared across
+// prompt changes.
+const PromptVersion = "v1"
+
+type ChatResponse struct {
+	Status  string           `json:"status"`
+	Message string           `json:"message"`
+	Tasks   []TaskAssignment `json:"tasks"`
+	Usage   *Usage           `json:"usage,omitempty"`
+}
+
+type TaskAssignment struct {
+	Task       string `json:"task"`
+	AssignedTo string `json:"assigned_to"`
+}
+
+type chatRequest struct {
+	Prompt string `json:"prompt"`
+}
+
+// Client talks to the agent service that breaks requirements down into tasks.
+type Client struct {
+	baseURL    string
+	backend    string
+	pricing    Pricing
+	httpClient *http.Client
+}
+
+func NewClient(baseURL, backend string, pricing Pricing) *Client {
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		backend:    backend,
+		pricing:    pricing,
+		httpClient: &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)},
+	}
+}
+
+// Backend returns the name of the planner implementation behind the client.
+func (c *Client) Backend() string {
+	return c.backend
+}
+
+// BuildPrompt renders the project requirements and team skills into the
+// prompt sent to the planner.
+func BuildPrompt(requirements string, employeeSkills []string) string {
+	return fmt.Sprintf("Project Requirements: %s\nTeam Members and Skills:\n%s",
+		requirements,
+		strings.Join(employeeSkills, "\n"))
+}
+
+// Generate sends the prompt to the planner and returns its response. The
+// response always carries usage: the planner's own figures when it reports
+// them, otherwise an estimate derived from the prompt and response text.
+func (c *Client) Generate(ctx context.Context, prompt string) (_ *ChatResponse, err error) {
+	ctx, span := otel.Tracer("nstorm.com/main-backend/planner").Start(ctx, "planner.Generate",
+		trace.WithAttributes(
+			attribute.String("planner.backend", c.backend),
+			attribute.String("planner.prompt_version", PromptVersion),
+			attribute.Int("planner.prompt_chars", len(prompt)),
+		),
+	)
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	body, err := json.Marshal(chatRequest{Prompt: prompt})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/chat", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if id := middleware.RequestIDFromContext(ctx); id != "" {
+		req.Header.Set(middleware.RequestIDHeader, id)
+	}
+
+	start := time.Now()
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		c.observe(start, false)
+		slog.ErrorContext(ctx, "planner call failed", "backend", c.backend, "duration", time.Since(start), "error", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		c.observe(start, false)
+		slog.ErrorContext(ctx, "planner call failed", "backend", c.backend, "duration", time.Since(start), "status", resp.StatusCode)
+		return nil, fmt.Errorf("planner returned %s", resp.Status)
+	}
+
+	var chatResponse ChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&chatResponse); err != nil {
+		c.observe(start, false)
+		return nil, err
+	}
+	c.observe(start, true)
+
+	if chatResponse.Usage == nil || chatResponse.Usage.TotalTokens == 0 {
+		chatResponse.Usage = estimateUsage(prompt, &chatResponse)
+	}
+	if chatResponse.Usage.CostUSD == 0 {
+		chatResponse.Usage.CostUSD = c.pricing.Cost(*chatResponse.Usage)
+	}
+
+	slog.InfoContext(ctx, "planner call",
+		"backend", c.backend,
+		"duration", time.Since(start),
+		"tasks", len(chatResponse.Tasks),
+		"total_tokens", chatResponse.Usage.TotalTokens,
+		"estimated", chatResponse.Usage.Estimated,
+	)
+
+	return &chatResponse, nil
+}
+
+func (c *Client) observe(start time.Time, ok bool) {
+	metrics.PlannerCallDuration.WithLabelValues(c.backend).Observe(time.Since(start).Seconds())
+	if !ok {
+		metrics.PlannerCallFailures.WithLabelValues(c.backend).Inc()
+	}
+}
+
+// Ping checks that the planner service is up.
+func (c *Client) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/health", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("planner returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package planner
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"nstorm.com/main-backend/models"
+)
+
+var (
+	ErrPromptInjection = errors.New("possible prompt injection")
+	ErrPromptTooLarge  = errors.New("prompt too large")
+)
+
+var injectionPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\b(ignore|disregard|forget|override)\s+(all\s+|any\s+|the\s+|your\s+)?(previous|prior|above|earlier|preceding|system)\s+(instructions|prompts?|rules|messages|context)`),
+	regexp.MustCompile(`(?i)\bforget\s+(everything|all)\s+(you|above|before)`),
+	regexp.MustCompile(`(?i)\b(reveal|print|show|repeat|leak)\s+(me\s+)?(your\s+(system\s+prompt|instructions|hidden\s+prompt)|the\s+(system|hidden)\s+prompt)`),
+	regexp.MustCompile(`(?i)\byou\s+are\s+now\s+(a|an|the|in|no\s+longer)\b`),
+	regexp.MustCompile(`(?i)\bnew\s+(system\s+)?instructions\s*:`),
+	regexp.MustCompile(`(?im)^\s*(system|assistant)\s*:`),
+	regexp.MustCompile(`(?i)<\|?\s*(im_start|im_end|system|endoftext)\s*\|?>`),
+}
+
+type piiPattern struct {
+	kind    string
+	pattern *regexp.Regexp
+	// valid, when set, filters out matches that only look like PII.
+	valid func(string) bool
+}
+
+// Order matters: card numbers must be masked before the looser phone pattern
+// gets a chance to match part of them.
+var piiPatterns = []piiPattern{
+	{kind: "email", pattern: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)},
+	{kind: "credit_card", pattern: regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`), valid: luhnValid},
+	{kind: "ssn", pattern: regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`)},
+	{kind: "ip_address", pattern: regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`), valid: octetsValid},
+	{kind: "phone", pattern: regexp.MustCompile(`(?:\+\d{1,3}[\s.-]?)?(?:\(\d{2,4}\)|\d{2,4})[\s.-]?\d{3,4}[\s.-]?\d{3,4}\b`), valid: enoughDigits(9)},
+}
+
+// Guard is the pre-flight filter applied to user supplied text before it is
+// sent to the planner.
+type Guard struct {
+	maxPromptChars int
+}
+
+func NewGuard(maxPromptChars int) *Guard {
+	return &Guard{maxPromptChars: maxPromptChars}
+}
+
+// Filtered is the outcome of running a generation request through the guard.
+type Filtered struct {
+	Prompt       string
+	Requirements string
+	Redactions   []models.Redaction
+}
+
+// Filter masks PII in the requirements and team description, rejects text
+// that tries to override the planner's instructions and enforces the maximum
+// prompt size. The returned Filtered is populated even when an error is
+// returned so that rejected runs can still be recorded.
+func (g *Guard) Filter(requirements string, employeeSkills []string) (*Filtered, error) {
+	counts := make(map[string]int)
+	filtered := &Filtered{Requirements: redact(requirements, counts)}
+	skills := make([]string, len(employeeSkills))
+	for i, line := range employeeSkills {
+		skills[i] = redact(line, counts)
+	}
+	filtered.Prompt = BuildPrompt(filtered.Requirements, skills)
+	filtered.Redactions = redactions(counts)
+
+	if match := detectInjection(requirements); match != "" {
+		return filtered, fmt.Errorf("%w in requirements: %q", ErrPromptInjection, match)
+	}
+	for _, line := range employeeSkills {
+		if match := detectInjection(line); match != "" {
+			return filtered, fmt.Errorf("%w in team skills: %q", ErrPromptInjection, match)
+		}
+	}
+
+	if size := utf8.RuneCountInString(filtered.Prompt); g.maxPromptChars > 0 && size > g.maxPromptChars {
+		return filtered, fmt.Errorf("%w: %d characters, limit is %d", ErrPromptTooLarge, size, g.maxPromptChars)
+	}
+
+	return filtered, nil
+}
+
+func detectInjection(text string) string {
+	for _, pattern := range injectionPatterns {
+		if match := pattern.FindString(text); match != "" {
+			return strings.TrimSpace(match)
+		}
+	}
+	return ""
+}
+
+// redact masks the PII in text and counts it by kind. Matches that are only
+// part of a longer word or number, such as the tail of a long ID or four
+// parts of a version like 1.2.3.4.5, are left alone.
+func redact(text string, counts map[string]int) string {
+	for _, p := range piiPatterns {
+		var b strings.Builder
+		last := 0
+		for _, loc := range p.pattern.FindAllStringIndex(text, -1) {
+			match := text[loc[0]:loc[1]]
+			if embedded(text, loc[0], loc[1]) || (p.valid != nil && !p.valid(match)) {
+				continue
+			}
+			b.WriteString(text[last:loc[0]])
+			b.WriteString("[REDACTED_" + strings.ToUpper(p.kind) + "]")
+			last = loc[1]
+			counts[p.kind]++
+		}
+		b.WriteString(text[last:])
+		text = b.String()
+	}
+	return text
+}
+
+// embedded reports whether text[start:end] continues a letter or digit on
+// either side, directly or across a dot.
+func embedded(text string, start, end int) bool {
+	if start > 0 && (isAlnum(text[start-1]) || (text[start-1] == '.' && start > 1 && isDigit(text[start-2]))) {
+		return true
+	}
+	if end < len(text) && (isAlnum(text[end]) || (text[end] == '.' && end+1 < len(text) && isDigit(text[end+1]))) {
+		return true
+	}
+	return false
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isAlnum(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// octetsValid rejects dotted numbers with a part above 255.
+func octetsValid(s string) bool {
+	for _, part := range strings.Split(s, ".") {
+		if n, err := strconv.Atoi(part); err != nil || n > 255 {
+			return false
+		}
+	}
+	return true
+}
+
+// redactions lists the number of masked matches per kind, in pattern order.
+func redactions(counts map[string]int) []models.Redaction {
+	var list []models.Redaction
+	for _, p := range piiPatterns {
+		if counts[p.kind] > 0 {
+			list = append(list, models.Redaction{Kind: p.kind, Count: counts[p.kind]})
+		}
+	}
+	return list
+}
+
+func digitsOf(s string) []int {
+	var digits []int
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			digits = append(digits, int(r-'0'))
+		}
+	}
+	return digits
+}
+
+func enoughDigits(n int) func(string) bool {
+	return func(s string) bool {
+		return len(digitsOf(s)) >= n
+	}
+}
+
+func luhnValid(s string) bool {
+	digits := digitsOf(s)
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+	sum := 0
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := digits[i]
+		if (len(digits)-i)%2 == 0 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
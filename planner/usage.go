@@ -0,0 +1,60 @@
+package planner
+
+import (
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// Usage is the token consumption of a single planner call.
+type Usage struct {
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+	Estimated        bool    `json:"estimated"`
+}
+
+// Pricing holds USD prices per 1K tokens.
+type Pricing struct {
+	PromptPer1K     float64
+	CompletionPer1K float64
+}
+
+func (p Pricing) Cost(u Usage) float64 {
+	return float64(u.PromptTokens)/1000*p.PromptPer1K +
+		float64(u.CompletionTokens)/1000*p.CompletionPer1K
+}
+
+// EstimateTokens approximates the number of tokens a BPE tokenizer would
+// produce for text. It takes the larger of the usual "four characters per
+// token" and "three words per four tokens" heuristics, which keeps the
+// estimate on the safe side for both prose and code.
+func EstimateTokens(text string) int {
+	if text == "" {
+		return 0
+	}
+	byChars := math.Ceil(float64(utf8.RuneCountInString(text)) / 4)
+	byWords := math.Ceil(float64(len(strings.Fields(text))) * 4 / 3)
+	return int(math.Max(byChars, byWords))
+}
+
+func estimateUsage(prompt string, resp *ChatResponse) *Usage {
+	var completion strings.Builder
+	completion.WriteString(resp.Message)
+	for _, task := range resp.Tasks {
+		completion.WriteString("\n[")
+		completion.WriteString(task.Task)
+		completion.WriteString("] {")
+		completion.WriteString(task.AssignedTo)
+		completion.WriteString("}")
+	}
+
+	usage := &Usage{
+		PromptTokens:     EstimateTokens(prompt),
+		CompletionTokens: EstimateTokens(completion.String()),
+		Estimated:        true,
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return usage
+}
@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit describes a token bucket: Burst tokens at most, refilled at Burst
+// tokens per Period. The zero Limit disables limiting.
+type Limit struct {
+	Burst  int
+	Period time.Duration
+}
+
+func (l Limit) Enabled() bool {
+	return l.Burst > 0 && l.Period > 0
+}
+
+// interval is the time it takes to refill a single token.
+func (l Limit) interval() time.Duration {
+	return l.Period / time.Duration(l.Burst)
+}
+
+func (l Limit) String() string {
+	if !l.Enabled() {
+		return "off"
+	}
+	return fmt.Sprintf("%d/%s", l.Burst, l.Period)
+}
+
+// ParseLimit parses limits written as "<requests>/<period>", for example
+// "120/1m" or "10/1h". "off" and "0" disable the limit.
+func ParseLimit(value string) (Limit, error) {
+	value = strings.TrimSpace(value)
+	if value == "off" || value == "0" {
+		return Limit{}, nil
+	}
+	count, period, ok := strings.Cut(value, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("rate limit %q must look like 120/1m", value)
+	}
+	burst, err := strconv.Atoi(count)
+	if err != nil || burst < 0 {
+		return Limit{}, fmt.Errorf("rate limit %q has an invalid request count", value)
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("rate limit %q has an invalid period", value)
+	}
+	return Limit{Burst: burst, Period: d}, nil
+}
+
+// Result is the state of a bucket after a request has been counted.
+type Result struct {
+	Allowed   bool
+	Remaining int
+	// RetryAfter is how long until the next token is available. It is only
+	// set when the request was not allowed.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+// Store keeps token buckets. Implementations must be safe for concurrent use
+// so a shared backend such as Redis can replace the in-memory one when the
+// service runs with more than one replica.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
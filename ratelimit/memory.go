@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepEvery is how many calls to Take pass between removals of full buckets.
+const sweepEvery = 1024
+
+// MemoryStore keeps buckets in process memory. Limits are per instance.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	calls   int
+}
+
+// bucket is stored as the time at which it will be full again, which is
+// all a token bucket needs: the tokens available at t are
+// Burst - ceil((full - t) / interval).
+type bucket struct {
+	full  time.Time
+	limit Limit
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*bucket)}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.calls++
+	if s.calls%sweepEvery == 0 {
+		s.sweep(now)
+	}
+
+	b, ok := s.buckets[key]
+	if !ok || b.limit != limit {
+		b = &bucket{full: now, limit: limit}
+		s.buckets[key] = b
+	}
+	if b.full.Before(now) {
+		b.full = now
+	}
+
+	interval := limit.interval()
+	// Taking a token pushes the full time one interval further out; the
+	// request fits if that stays within one period of now.
+	next := b.full.Add(interval)
+	if next.Sub(now) > limit.Period {
+		return Result{
+			Allowed:    false,
+			Remaining:  0,
+			RetryAfter: next.Sub(now) - limit.Period,
+			Reset:      b.full.Sub(now),
+		}, nil
+	}
+
+	b.full = next
+	return Result{
+		Allowed:   true,
+		Remaining: int((limit.Period - next.Sub(now)) / interval),
+		Reset:     next.Sub(now),
+	}, nil
+}
+
+// sweep drops buckets that have refilled; they behave exactly like new ones.
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		if !b.full.After(now) {
+			delete(s.buckets, key)
+		}
+	}
+}
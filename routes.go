@@ -0,0 +1,163 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"nstorm.com/main-backend/docs"
+	"nstorm.com/main-backend/handlers"
+)
+
+// server holds what the routes dispatch to. Every route must also be
+// described in docs/openapi.json; routes_test.go enforces this.
+type server struct {
+	employees    *handlers.EmployeeHandler
+	projects     *handlers.ProjectHandler
+	tasks        *handlers.TaskHandler
+	dependencies *handlers.DependencyHandler
+	comments     *handlers.CommentHandler
+	labels       *handlers.LabelHandler
+	attachments  *handlers.AttachmentHandler
+	time         *handlers.TimeHandler
+	sprints      *handlers.SprintHandler
+	milestones   *handlers.MilestoneHandler
+	schedules    *handlers.ScheduleHandler
+	usage        *handlers.UsageHandler
+	feedback     *handlers.FeedbackHandler
+	auth         *handlers.AuthHandler
+	audit        *handlers.AuditHandler
+	health       *handlers.HealthHandler
+
+	ipRateLimit  mux.MiddlewareFunc
+	authenticate mux.MiddlewareFunc
+	rateLimit    mux.MiddlewareFunc
+	idempotency  mux.MiddlewareFunc
+}
+
+func (s *server) routes() *mux.Router {
+	router := mux.NewRouter()
+	router.Handle("/metrics", promhttp.Handler()).Methods("GET")
+	router.HandleFunc("/openapi.json", docs.ServeSpec).Methods("GET")
+	router.HandleFunc("/docs", docs.ServeUI).Methods("GET")
+	router.HandleFunc("/healthz", s.health.Healthz).Methods("GET")
+	router.HandleFunc("/readyz", s.health.Readyz).Methods("GET")
+	router.HandleFunc("/status", s.health.Status).Methods("GET")
+	router.Handle("/auth/login", s.rateLimit(http.HandlerFunc(s.auth.Login))).Methods("POST")
+
+	// Everything else requires a JWT or an API key. Clients are limited by IP
+	// before their credentials are checked.
+	api := router.NewRoute().Subrouter()
+	api.Use(s.ipRateLimit)
+	api.Use(s.authenticate)
+	api.Use(s.rateLimit)
+	api.Use(s.idempotency)
+
+	api.HandleFunc("/auth/me", s.auth.Me).Methods("GET")
+	api.HandleFunc("/employees/{id}/password", s.auth.SetPassword).Methods("PUT")
+	api.HandleFunc("/api-keys", s.auth.GetAPIKeys).Methods("GET")
+	api.HandleFunc("/api-keys", s.auth.CreateAPIKey).Methods("POST")
+	api.HandleFunc("/api-keys/{id}", s.auth.RevokeAPIKey).Methods("DELETE")
+
+	api.HandleFunc("/employees", s.employees.GetAllEmployees).Methods("GET")
+	api.HandleFunc("/employees", s.employees.CreateEmployee).Methods("POST")
+	api.HandleFunc("/employees/{id}", s.employees.GetEmployeeById).Methods("GET")
+	api.HandleFunc("/employees/{id}", s.employees.UpdateEmployee).Methods("PUT")
+	api.HandleFunc("/employees/{id}", s.employees.DeleteEmployee).Methods("DELETE")
+
+	api.HandleFunc("/employees/{id}/tasks", s.employees.GetEmployeeTasks).Methods("GET")
+	api.HandleFunc("/employees/{id}/tasks/{status}", s.employees.GetEmployeeTasksByStatus).Methods("GET")
+	api.HandleFunc("/employees/{id}/projects", s.employees.GetEmployeeProjects).Methods("GET")
+	api.HandleFunc("/employees/{employeeId}/projects/{projectId}", s.employees.AssignEmployeeToProject).Methods("POST")
+	api.HandleFunc("/employees/{employeeId}/projects/{projectId}", s.employees.UpdateProjectMembership).Methods("PUT")
+	api.HandleFunc("/employees/{employeeId}/projects/{projectId}", s.employees.RemoveEmployeeFromProject).Methods("DELETE")
+	api.HandleFunc("/projects/{id}/employees", s.employees.GetEmployeesByProject).Methods("GET")
+
+	api.HandleFunc("/projects", s.projects.GetAllProjects).Methods("GET")
+	api.HandleFunc("/projects", s.projects.CreateProject).Methods("POST")
+	api.HandleFunc("/projects/{id}", s.projects.GetProjectByID).Methods("GET")
+	api.HandleFunc("/projects/{id}", s.projects.UpdateProject).Methods("PUT")
+	api.HandleFunc("/projects/{id}", s.projects.DeleteProject).Methods("DELETE")
+	api.HandleFunc("/projects/{id}/labels", s.labels.GetProjectLabels).Methods("GET")
+	api.HandleFunc("/projects/{id}/labels/{labelId}", s.labels.AddProjectLabel).Methods("POST")
+	api.HandleFunc("/projects/{id}/labels/{labelId}", s.labels.RemoveProjectLabel).Methods("DELETE")
+
+	api.HandleFunc("/projects/{id}/attachments", s.attachments.GetProjectAttachments).Methods("GET")
+	api.HandleFunc("/projects/{id}/attachments", s.attachments.UploadProjectAttachment).Methods("POST")
+
+	api.HandleFunc("/labels", s.labels.GetLabels).Methods("GET")
+	api.HandleFunc("/labels", s.labels.CreateLabel).Methods("POST")
+	api.HandleFunc("/labels/{id}", s.labels.UpdateLabel).Methods("PUT")
+	api.HandleFunc("/labels/{id}", s.labels.DeleteLabel).Methods("DELETE")
+
+	api.HandleFunc("/tasks", s.tasks.GetAllTasks).Methods("GET")
+	api.HandleFunc("/tasks", s.tasks.CreateTask).Methods("POST")
+	api.HandleFunc("/tasks/{id}", s.tasks.GetTaskByID).Methods("GET")
+	api.HandleFunc("/tasks/{id}", s.tasks.UpdateTask).Methods("PUT")
+	api.HandleFunc("/tasks/{id}/status", s.tasks.UpdateTaskStatus).Methods("PUT")
+	api.HandleFunc("/tasks/{id}", s.tasks.DeleteTask).Methods("DELETE")
+	api.HandleFunc("/tasks/{id}/subtasks", s.tasks.GetSubtasks).Methods("GET")
+	api.HandleFunc("/tasks/{id}/subtasks", s.tasks.CreateSubtask).Methods("POST")
+	api.HandleFunc("/tasks/{id}/tree", s.tasks.GetTaskTree).Methods("GET")
+	api.HandleFunc("/tasks/{id}/labels", s.labels.GetTaskLabels).Methods("GET")
+	api.HandleFunc("/tasks/{id}/labels/{labelId}", s.labels.AddTaskLabel).Methods("POST")
+	api.HandleFunc("/tasks/{id}/labels/{labelId}", s.labels.RemoveTaskLabel).Methods("DELETE")
+	api.HandleFunc("/tasks/{id}/attachments", s.attachments.GetTaskAttachments).Methods("GET")
+	api.HandleFunc("/tasks/{id}/attachments", s.attachments.UploadTaskAttachment).Methods("POST")
+	api.HandleFunc("/attachments/{id}", s.attachments.GetAttachment).Methods("GET")
+	api.HandleFunc("/attachments/{id}", s.attachments.DeleteAttachment).Methods("DELETE")
+	api.HandleFunc("/attachments/{id}/content", s.attachments.DownloadAttachment).Methods("GET")
+	api.HandleFunc("/tasks/{id}/time-entries", s.time.GetTaskTimeEntries).Methods("GET")
+	api.HandleFunc("/tasks/{id}/time-entries", s.time.CreateTimeEntry).Methods("POST")
+	api.HandleFunc("/tasks/{id}/timer/start", s.time.StartTimer).Methods("POST")
+	api.HandleFunc("/tasks/{id}/timer/stop", s.time.StopTimer).Methods("POST")
+	api.HandleFunc("/time-entries/{id}", s.time.GetTimeEntry).Methods("GET")
+	api.HandleFunc("/time-entries/{id}", s.time.UpdateTimeEntry).Methods("PUT")
+	api.HandleFunc("/time-entries/{id}", s.time.DeleteTimeEntry).Methods("DELETE")
+	api.HandleFunc("/employees/{id}/timesheet", s.time.GetEmployeeTimesheet).Methods("GET")
+	api.HandleFunc("/projects/{id}/timesheet", s.time.GetProjectTimesheet).Methods("GET")
+	api.HandleFunc("/tasks/{id}/comments", s.comments.GetTaskComments).Methods("GET")
+	api.HandleFunc("/tasks/{id}/comments", s.comments.CreateTaskComment).Methods("POST")
+	api.HandleFunc("/tasks/{id}/comments/{commentId}", s.comments.GetTaskComment).Methods("GET")
+	api.HandleFunc("/tasks/{id}/comments/{commentId}", s.comments.UpdateTaskComment).Methods("PUT")
+	api.HandleFunc("/tasks/{id}/comments/{commentId}", s.comments.DeleteTaskComment).Methods("DELETE")
+	api.HandleFunc("/tasks/{id}/comments/{commentId}/history", s.comments.GetCommentHistory).Methods("GET")
+	api.HandleFunc("/tasks/{id}/dependencies", s.dependencies.GetTaskDependencies).Methods("GET")
+	api.HandleFunc("/tasks/{id}/dependencies/{dependsOnId}", s.dependencies.AddTaskDependency).Methods("POST")
+	api.HandleFunc("/tasks/{id}/dependencies/{dependsOnId}", s.dependencies.RemoveTaskDependency).Methods("DELETE")
+	api.HandleFunc("/projects/{id}/sprints", s.sprints.GetProjectSprints).Methods("GET")
+	api.HandleFunc("/projects/{id}/sprints", s.sprints.CreateSprint).Methods("POST")
+	api.HandleFunc("/sprints/{id}", s.sprints.GetSprint).Methods("GET")
+	api.HandleFunc("/sprints/{id}", s.sprints.UpdateSprint).Methods("PUT")
+	api.HandleFunc("/sprints/{id}", s.sprints.DeleteSprint).Methods("DELETE")
+	api.HandleFunc("/sprints/{id}/start", s.sprints.StartSprint).Methods("POST")
+	api.HandleFunc("/sprints/{id}/close", s.sprints.CloseSprint).Methods("POST")
+	api.HandleFunc("/sprints/{id}/tasks", s.sprints.GetSprintTasks).Methods("GET")
+	api.HandleFunc("/sprints/{id}/tasks/{taskId}", s.sprints.AddSprintTask).Methods("POST")
+	api.HandleFunc("/sprints/{id}/tasks/{taskId}", s.sprints.RemoveSprintTask).Methods("DELETE")
+	api.HandleFunc("/projects/{id}/milestones", s.milestones.GetProjectMilestones).Methods("GET")
+	api.HandleFunc("/projects/{id}/milestones", s.milestones.CreateMilestone).Methods("POST")
+	api.HandleFunc("/milestones/{id}", s.milestones.GetMilestone).Methods("GET")
+	api.HandleFunc("/milestones/{id}", s.milestones.UpdateMilestone).Methods("PUT")
+	api.HandleFunc("/milestones/{id}", s.milestones.DeleteMilestone).Methods("DELETE")
+	api.HandleFunc("/milestones/{id}/tasks", s.milestones.GetMilestoneTasks).Methods("GET")
+	api.HandleFunc("/milestones/{id}/tasks/{taskId}", s.milestones.AddMilestoneTask).Methods("POST")
+	api.HandleFunc("/milestones/{id}/tasks/{taskId}", s.milestones.RemoveMilestoneTask).Methods("DELETE")
+	api.HandleFunc("/projects/{id}/dependency-graph", s.dependencies.GetDependencyGraph).Methods("GET")
+	api.HandleFunc("/projects/{id}/schedule", s.schedules.GetProjectSchedule).Methods("GET")
+	api.HandleFunc("/projects/{id}/generate-tasks", s.projects.GenerateAndAssignTasks).Methods("POST")
+
+	api.HandleFunc("/usage", s.usage.GetMonthlyUsage).Methods("GET")
+	api.HandleFunc("/projects/{id}/usage", s.usage.GetProjectUsage).Methods("GET")
+	api.HandleFunc("/projects/{id}/generation-runs", s.usage.GetGenerationRuns).Methods("GET")
+	api.HandleFunc("/projects/{id}/budget", s.usage.GetProjectBudget).Methods("GET")
+	api.HandleFunc("/projects/{id}/budget", s.usage.SetProjectBudget).Methods("PUT")
+
+	api.HandleFunc("/tasks/{id}/feedback", s.feedback.GetTaskFeedback).Methods("GET")
+	api.HandleFunc("/tasks/{id}/feedback", s.feedback.CreateTaskFeedback).Methods("POST")
+	api.HandleFunc("/evaluation/report", s.feedback.GetEvaluationReport).Methods("GET")
+
+	api.HandleFunc("/audit", s.audit.GetAuditEvents).Methods("GET")
+
+	return router
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"nstorm.com/main-backend/docs"
+)
+
+func passthrough(next http.Handler) http.Handler { return next }
+
+// TestRoutesDocumented fails when a registered route is missing from
+// docs/openapi.json, or the spec describes a route that is not registered.
+func TestRoutesDocumented(t *testing.T) {
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(docs.Spec, &spec); err != nil {
+		t.Fatalf("parse openapi.json: %v", err)
+	}
+
+	router := (&server{
+		ipRateLimit:  passthrough,
+		authenticate: passthrough,
+		rateLimit:    passthrough,
+		idempotency:  passthrough,
+	}).routes()
+
+	registered := make(map[string]bool)
+	err := router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			// Subrouters have no path of their own
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			t.Errorf("route %s has no methods", path)
+			return nil
+		}
+		for _, method := range methods {
+			registered[method+" "+path] = true
+			if _, ok := spec.Paths[path][strings.ToLower(method)]; !ok {
+				t.Errorf("route %s %s is not described in docs/openapi.json", method, path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, operations := range spec.Paths {
+		for method := range operations {
+			if !registered[strings.ToUpper(method)+" "+path] {
+				t.Errorf("docs/openapi.json describes %s %s, which is not registered", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package schedule
+
+import (
+	"time"
+
+	"nstorm.com/main-backend/models"
+)
+
+// Schedules count in working days, Monday to Friday, numbered from the first
+// working day on or after the schedule start.
+
+func isWorkday(d models.Date) bool {
+	switch d.Weekday() {
+	case time.Saturday, time.Sunday:
+		return false
+	}
+	return true
+}
+
+func nextWorkday(d models.Date) models.Date {
+	for !isWorkday(d) {
+		d = models.Date{Time: d.AddDate(0, 0, 1)}
+	}
+	return d
+}
+
+// workday returns the date of working day n.
+func workday(start models.Date, n int) models.Date {
+	d := nextWorkday(start)
+	for weeks := n / 5; weeks > 0; weeks-- {
+		d = models.Date{Time: d.AddDate(0, 0, 7)}
+	}
+	for n %= 5; n > 0; n-- {
+		d = nextWorkday(models.Date{Time: d.AddDate(0, 0, 1)})
+	}
+	return d
+}
+
+// workdayOffset returns the number of the first working day on or after d.
+// Dates before the start map to day 0.
+func workdayOffset(start, d models.Date) int {
+	first := nextWorkday(start)
+	if !d.After(first.Time) {
+		return 0
+	}
+	days := int(d.Sub(first.Time).Hours() / 24)
+	n := days / 7 * 5
+	for cursor := first.AddDate(0, 0, days/7*7); cursor.Before(d.Time); cursor = cursor.AddDate(0, 0, 1) {
+		if isWorkday(models.Date{Time: cursor}) {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,71 @@
+package schedule
+
+import (
+	"math"
+
+	"nstorm.com/main-backend/models"
+)
+
+// Progress measures the tasks linked to milestoneID, due on target, among
+// tasks. plan is the schedule of their project, computed by Compute, so
+// allocation, assignee availability and dependencies all count towards the
+// projected finish: the latest earliest finish of the open tasks. The
+// milestone is at risk when that falls after the target date. The work of a
+// parent task is that of its subtasks, linked or not.
+func Progress(milestoneID int, target models.Date, tasks []models.Task, plan *models.ProjectSchedule, opts Options) models.MilestoneProgress {
+	var progress models.MilestoneProgress
+	finishes := make(map[int]*models.Date)
+	if plan != nil {
+		for _, scheduled := range plan.Tasks {
+			finishes[scheduled.TaskID] = scheduled.EarliestFinish
+		}
+	}
+
+	children := subtasks(tasks)
+	covered := make(map[int]bool)
+	var cover func(id int)
+	cover = func(id int) {
+		if !covered[id] {
+			covered[id] = true
+			for _, child := range children[id] {
+				cover(child)
+			}
+		}
+	}
+	for _, task := range tasks {
+		if task.MilestoneID == nil || *task.MilestoneID != milestoneID {
+			continue
+		}
+		progress.Tasks++
+		if task.Status == models.TaskStatusDone {
+			progress.DoneTasks++
+		}
+		cover(task.ID)
+	}
+
+	for _, task := range tasks {
+		if !covered[task.ID] || task.Status == models.TaskStatusDone || len(children[task.ID]) > 0 {
+			continue
+		}
+
+		hours, ok := estimate(task, opts)
+		if !ok {
+			progress.UnestimatedTasks++
+		}
+		if task.ActualHours != nil {
+			hours -= *task.ActualHours
+		}
+		progress.RemainingHours += max(hours, 0)
+
+		if finish := finishes[task.ID]; finish != nil && (progress.ProjectedFinish == nil || finish.After(progress.ProjectedFinish.Time)) {
+			progress.ProjectedFinish = finish
+		}
+	}
+
+	if progress.Tasks > 0 {
+		progress.Percent = math.Round(float64(progress.DoneTasks)/float64(progress.Tasks)*1000) / 10
+	}
+	progress.RemainingHours = math.Round(progress.RemainingHours*100) / 100
+	progress.AtRisk = progress.ProjectedFinish != nil && progress.ProjectedFinish.After(target.Time)
+	return progress
+}
@@ -0,0 +1,409 @@
+// Package schedule computes project timelines from task estimates,
+// dependencies and assignee availability using the critical path method.
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+
+	"nstorm.com/main-backend/models"
+)
+
+// ErrCycle is returned when the dependencies contain a cycle, which the API
+// normally prevents.
+var ErrCycle = errors.New("task dependencies contain a cycle")
+
+// Assignee describes when and how much an employee works on the project.
+type Assignee struct {
+	AllocationPercent int
+	StartDate         *models.Date
+	EndDate           *models.Date
+}
+
+type Options struct {
+	// Start is the first day work can be scheduled on.
+	Start              models.Date
+	HoursPerDay        float64
+	HoursPerStoryPoint float64
+	// DefaultDays is the duration of tasks without an estimate.
+	DefaultDays int
+}
+
+// Input is a project's tasks and what constrains them. Tasks may include
+// prerequisites from other projects; they are not scheduled but their status
+// and due date constrain the tasks depending on them.
+type Input struct {
+	ProjectID    int
+	Tasks        []models.Task
+	Dependencies []models.DependencyEdge
+	Assignees    map[int]Assignee
+}
+
+type node struct {
+	task     models.Task
+	days     int
+	progress float64
+
+	// children are the subtasks of a parent task. A parent adds no work of
+	// its own: it depends on them and spans their dates.
+	children      []int
+	prerequisites []int
+	dependents    []int
+	// successors adds the next task of the same assignee to dependents so
+	// the backward pass respects the levelled order
+	successors []int
+
+	es, ef, ls, lf int
+}
+
+// Compute schedules the open tasks of in.ProjectID. Tasks are placed in
+// dependency order as early as their prerequisites, start date and assignee
+// allow; an assignee works on one task at a time, higher priority first.
+// Parent tasks run from the first start to the last finish of their
+// subtasks, which also wait for the parent's prerequisites.
+func Compute(in Input, opts Options) (*models.ProjectSchedule, error) {
+	start := nextWorkday(opts.Start)
+	result := &models.ProjectSchedule{
+		ProjectID:    in.ProjectID,
+		StartDate:    start,
+		CriticalPath: []int{},
+		Tasks:        []models.ScheduledTask{},
+		Warnings:     []string{},
+	}
+	warn := func(format string, args ...any) {
+		result.Warnings = append(result.Warnings, fmt.Sprintf(format, args...))
+	}
+
+	all := make(map[int]models.Task, len(in.Tasks))
+	nodes := make(map[int]*node)
+	for _, task := range in.Tasks {
+		all[task.ID] = task
+		if task.ProjectID != in.ProjectID {
+			continue
+		}
+		nodes[task.ID] = &node{task: task}
+	}
+	children := subtasks(in.Tasks)
+	for _, task := range in.Tasks {
+		n, ok := nodes[task.ID]
+		if !ok {
+			continue
+		}
+		for _, id := range children[task.ID] {
+			if _, ok := nodes[id]; ok {
+				n.children = append(n.children, id)
+				n.prerequisites = append(n.prerequisites, id)
+				nodes[id].dependents = append(nodes[id].dependents, task.ID)
+			}
+		}
+		if len(n.children) == 0 {
+			n.days, n.progress = duration(task, in.Assignees, opts, warn)
+		}
+	}
+
+	// External prerequisites that are still open hold their dependents back
+	// until their due date, the only date known for them
+	external := make(map[int]int)
+	edges := append([]models.DependencyEdge{}, in.Dependencies...)
+	for len(edges) > 0 {
+		edge := edges[0]
+		edges = edges[1:]
+		to, ok := nodes[edge.To]
+		if !ok {
+			continue
+		}
+		// A parent already waits for its subtasks, and a subtask cannot wait
+		// for its own parent to finish
+		if ancestor(all, edge.To, edge.From) {
+			continue
+		}
+		if ancestor(all, edge.From, edge.To) {
+			warn("task %d depends on its parent task %d; ignoring the dependency", edge.To, edge.From)
+			continue
+		}
+		for _, id := range to.children {
+			edges = append(edges, models.DependencyEdge{From: edge.From, To: id})
+		}
+		if from, ok := nodes[edge.From]; ok {
+			if !slices.Contains(to.prerequisites, edge.From) {
+				to.prerequisites = append(to.prerequisites, edge.From)
+				from.dependents = append(from.dependents, edge.To)
+			}
+			continue
+		}
+		prerequisite, ok := all[edge.From]
+		if !ok || prerequisite.Status == models.TaskStatusDone {
+			continue
+		}
+		if prerequisite.DueDate == nil {
+			warn("task %d depends on open task %d of another project, which has no due date", edge.To, edge.From)
+			continue
+		}
+		if offset := workdayOffset(start, *prerequisite.DueDate) + 1; offset > external[edge.To] {
+			external[edge.To] = offset
+		}
+	}
+
+	order, err := topologicalOrder(nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	// Forward pass
+	busyUntil := make(map[int]int)
+	lastTask := make(map[int]*node)
+	for _, n := range order {
+		if len(n.children) > 0 {
+			span(n, nodes, external[n.task.ID])
+			continue
+		}
+		if n.days == 0 {
+			continue
+		}
+		es := external[n.task.ID]
+		for _, id := range n.prerequisites {
+			es = max(es, nodes[id].ef)
+		}
+		if n.task.StartDate != nil {
+			es = max(es, workdayOffset(start, *n.task.StartDate))
+		}
+		if assignee := n.task.AssignedTo; assignee != 0 {
+			if a, ok := in.Assignees[assignee]; ok && a.StartDate != nil {
+				es = max(es, workdayOffset(start, *a.StartDate))
+			}
+			es = max(es, busyUntil[assignee])
+			if prev := lastTask[assignee]; prev != nil {
+				prev.successors = append(prev.successors, n.task.ID)
+			}
+			lastTask[assignee] = n
+		}
+		n.es, n.ef = es, es+n.days
+		if n.task.AssignedTo != 0 {
+			busyUntil[n.task.AssignedTo] = n.ef
+		}
+	}
+
+	finish := 0
+	for _, n := range order {
+		finish = max(finish, n.ef)
+	}
+
+	// Backward pass
+	for i := len(order) - 1; i >= 0; i-- {
+		n := order[i]
+		if n.days == 0 {
+			continue
+		}
+		n.lf = finish
+		for _, id := range append(n.dependents, n.successors...) {
+			next := nodes[id]
+			switch {
+			case next.days == 0:
+			case n.task.ParentTaskID != nil && *n.task.ParentTaskID == id:
+				// Subtasks only have to finish with their parent
+				n.lf = min(n.lf, next.lf)
+			default:
+				n.lf = min(n.lf, next.ls)
+			}
+		}
+		n.ls = n.lf - n.days
+	}
+
+	if finish > 0 {
+		finishDate := workday(start, finish-1)
+		result.FinishDate = &finishDate
+	}
+	result.DurationDays = finish
+
+	for _, n := range order {
+		scheduled := models.ScheduledTask{
+			TaskID:       n.task.ID,
+			Title:        n.task.Title,
+			AssignedTo:   n.task.AssignedTo,
+			Status:       n.task.Status,
+			Priority:     n.task.Priority,
+			DurationDays: n.days,
+			Progress:     n.progress,
+			DependsOn:    append([]int{}, n.prerequisites...),
+		}
+		if n.days > 0 {
+			scheduled.EarliestStart = dateOf(start, n.es)
+			scheduled.EarliestFinish = dateOf(start, n.ef-1)
+			scheduled.LatestStart = dateOf(start, n.ls)
+			scheduled.LatestFinish = dateOf(start, n.lf-1)
+			scheduled.SlackDays = n.ls - n.es
+			scheduled.Critical = scheduled.SlackDays == 0
+
+			if due := n.task.DueDate; due != nil && scheduled.EarliestFinish.After(due.Time) {
+				scheduled.Late = true
+				warn("task %d finishes %s, after its due date %s", n.task.ID, scheduled.EarliestFinish, due)
+			}
+			if a, ok := in.Assignees[n.task.AssignedTo]; ok && len(n.children) == 0 && a.EndDate != nil && scheduled.EarliestFinish.After(a.EndDate.Time) {
+				warn("task %d finishes %s, after its assignee leaves the project on %s", n.task.ID, scheduled.EarliestFinish, a.EndDate)
+			}
+		}
+		result.Tasks = append(result.Tasks, scheduled)
+	}
+
+	critical := make([]*node, 0)
+	for _, n := range order {
+		if n.days > 0 && len(n.children) == 0 && n.ls == n.es {
+			critical = append(critical, n)
+		}
+	}
+	sort.SliceStable(critical, func(i, j int) bool { return critical[i].es < critical[j].es })
+	for _, n := range critical {
+		result.CriticalPath = append(result.CriticalPath, n.task.ID)
+	}
+
+	return result, nil
+}
+
+// span places a parent task from the earliest start of its scheduled
+// subtasks to the latest finish of its prerequisites, which include them. A
+// parent without open subtasks is not scheduled.
+func span(n *node, nodes map[int]*node, external int) {
+	scheduled := false
+	for _, id := range n.children {
+		if child := nodes[id]; child.days > 0 && (!scheduled || child.es < n.es) {
+			n.es, scheduled = child.es, true
+		}
+	}
+	if !scheduled {
+		return
+	}
+	n.ef = external
+	for _, id := range n.prerequisites {
+		n.ef = max(n.ef, nodes[id].ef)
+	}
+	n.days = n.ef - n.es
+}
+
+// subtasks maps the tasks with subtasks among tasks to their IDs.
+func subtasks(tasks []models.Task) map[int][]int {
+	children := make(map[int][]int)
+	for _, task := range tasks {
+		if task.ParentTaskID != nil {
+			children[*task.ParentTaskID] = append(children[*task.ParentTaskID], task.ID)
+		}
+	}
+	return children
+}
+
+// ancestor reports whether task a is a parent of task b at any depth.
+func ancestor(tasks map[int]models.Task, a, b int) bool {
+	for depth := 0; depth < len(tasks); depth++ {
+		task, ok := tasks[b]
+		if !ok || task.ParentTaskID == nil {
+			return false
+		}
+		if b = *task.ParentTaskID; b == a {
+			return true
+		}
+	}
+	return false
+}
+
+// duration returns the remaining working days of task and the share of its
+// estimate already logged. Done tasks take no time.
+func duration(task models.Task, assignees map[int]Assignee, opts Options, warn func(string, ...any)) (int, float64) {
+	hours, ok := estimate(task, opts)
+	if !ok && task.Status != models.TaskStatusDone {
+		warn("task %d has no estimate; assuming %d day(s)", task.ID, opts.DefaultDays)
+	}
+
+	var progress float64
+	if task.Status == models.TaskStatusDone {
+		return 0, 1
+	}
+	remaining := hours
+	if task.ActualHours != nil {
+		remaining -= *task.ActualHours
+		if hours > 0 {
+			progress = math.Min(*task.ActualHours/hours, 1)
+		}
+	}
+
+	capacity := opts.HoursPerDay
+	if a, ok := assignees[task.AssignedTo]; ok && task.AssignedTo != 0 {
+		if a.AllocationPercent > 0 {
+			capacity = opts.HoursPerDay * float64(a.AllocationPercent) / 100
+		} else {
+			warn("task %d is assigned to employee %d, who has no allocation on the project", task.ID, task.AssignedTo)
+		}
+	}
+
+	// Open tasks take at least a day, even when the estimate is used up
+	return max(1, int(math.Ceil(remaining/capacity))), progress
+}
+
+// estimate returns the estimated hours of task from estimate_hours, else its
+// story points. Tasks without either count as DefaultDays and report false.
+func estimate(task models.Task, opts Options) (float64, bool) {
+	switch {
+	case task.EstimateHours != nil:
+		return *task.EstimateHours, true
+	case task.StoryPoints != nil:
+		return float64(*task.StoryPoints) * opts.HoursPerStoryPoint, true
+	}
+	return float64(opts.DefaultDays) * opts.HoursPerDay, false
+}
+
+// topologicalOrder orders nodes so prerequisites come first. Among tasks that
+// are ready at the same time, higher priority, earlier due date and lower ID
+// go first.
+func topologicalOrder(nodes map[int]*node) ([]*node, error) {
+	pending := make(map[int]int, len(nodes))
+	var ready []*node
+	for id, n := range nodes {
+		pending[id] = len(n.prerequisites)
+		if pending[id] == 0 {
+			ready = append(ready, n)
+		}
+	}
+
+	order := make([]*node, 0, len(nodes))
+	for len(ready) > 0 {
+		sort.Slice(ready, func(i, j int) bool { return before(ready[i].task, ready[j].task) })
+		n := ready[0]
+		ready = ready[1:]
+		order = append(order, n)
+		for _, id := range n.dependents {
+			if pending[id]--; pending[id] == 0 {
+				ready = append(ready, nodes[id])
+			}
+		}
+	}
+	if len(order) != len(nodes) {
+		return nil, ErrCycle
+	}
+	return order, nil
+}
+
+var priorityRank = map[models.TaskPriority]int{
+	models.PriorityUrgent: 0,
+	models.PriorityHigh:   1,
+	models.PriorityMedium: 2,
+	models.PriorityLow:    3,
+}
+
+func before(a, b models.Task) bool {
+	if priorityRank[a.Priority] != priorityRank[b.Priority] {
+		return priorityRank[a.Priority] < priorityRank[b.Priority]
+	}
+	if (a.DueDate == nil) != (b.DueDate == nil) {
+		return a.DueDate != nil
+	}
+	if a.DueDate != nil && !a.DueDate.Equal(b.DueDate.Time) {
+		return a.DueDate.Before(b.DueDate.Time)
+	}
+	return a.ID < b.ID
+}
+
+func dateOf(start models.Date, day int) *models.Date {
+	d := workday(start, day)
+	return &d
+}
@@ -0,0 +1,261 @@
+package schedule
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"nstorm.com/main-backend/models"
+)
+
+// monday is the start of every schedule below.
+var monday = models.NewDate(2026, time.October, 19)
+
+func date(month time.Month, day int) models.Date {
+	return models.NewDate(2026, month, day)
+}
+
+func hours(h float64) *float64 { return &h }
+
+func id(n int) *int { return &n }
+
+func task(taskID int, estimate float64) models.Task {
+	return models.Task{
+		ID:            taskID,
+		ProjectID:     1,
+		Title:         "task",
+		Status:        models.TaskStatusTodo,
+		Priority:      models.PriorityMedium,
+		EstimateHours: hours(estimate),
+	}
+}
+
+func TestWorkday(t *testing.T) {
+	tests := []struct {
+		start models.Date
+		n     int
+		want  models.Date
+	}{
+		{monday, 0, date(time.October, 19)},
+		{monday, 4, date(time.October, 23)},
+		{monday, 5, date(time.October, 26)},
+		{monday, 12, date(time.November, 4)},
+		{date(time.October, 24), 0, date(time.October, 26)},
+		{date(time.October, 25), 1, date(time.October, 27)},
+	}
+	for _, tt := range tests {
+		if got := workday(tt.start, tt.n); !got.Equal(tt.want.Time) {
+			t.Errorf("workday(%s, %d) = %s, want %s", tt.start, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestWorkdayOffset(t *testing.T) {
+	tests := []struct {
+		start, d models.Date
+		want     int
+	}{
+		{monday, date(time.October, 19), 0},
+		{monday, date(time.October, 23), 4},
+		{monday, date(time.October, 24), 5},
+		{monday, date(time.October, 25), 5},
+		{monday, date(time.October, 26), 5},
+		{monday, date(time.November, 4), 12},
+		{monday, date(time.October, 1), 0},
+		{date(time.October, 24), date(time.October, 27), 1},
+	}
+	for _, tt := range tests {
+		if got := workdayOffset(tt.start, tt.d); got != tt.want {
+			t.Errorf("workdayOffset(%s, %s) = %d, want %d", tt.start, tt.d, got, tt.want)
+		}
+	}
+	for n := range 30 {
+		if got := workdayOffset(monday, workday(monday, n)); got != n {
+			t.Errorf("workdayOffset(workday(%d)) = %d", n, got)
+		}
+	}
+}
+
+// planned is the expected timeline of one task.
+type planned struct {
+	start, finish string
+	days, slack   int
+}
+
+func TestCompute(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    Input
+		want     map[int]planned
+		critical []int
+		finish   string
+	}{
+		{
+			name: "chain and a parallel task",
+			input: Input{
+				Tasks:        []models.Task{task(1, 8), task(2, 16), task(3, 8)},
+				Dependencies: []models.DependencyEdge{{From: 1, To: 2}},
+			},
+			want: map[int]planned{
+				1: {"2026-10-19", "2026-10-19", 1, 0},
+				2: {"2026-10-20", "2026-10-21", 2, 0},
+				3: {"2026-10-19", "2026-10-19", 1, 2},
+			},
+			critical: []int{1, 2},
+			finish:   "2026-10-21",
+		},
+		{
+			name: "work crosses the weekend",
+			input: Input{
+				Tasks:        []models.Task{task(1, 32), task(2, 16)},
+				Dependencies: []models.DependencyEdge{{From: 1, To: 2}},
+			},
+			want: map[int]planned{
+				1: {"2026-10-19", "2026-10-22", 4, 0},
+				2: {"2026-10-23", "2026-10-26", 2, 0},
+			},
+			critical: []int{1, 2},
+			finish:   "2026-10-26",
+		},
+		{
+			name: "allocation scales the duration",
+			input: func() Input {
+				half := task(1, 16)
+				half.AssignedTo = 7
+				return Input{
+					Tasks:     []models.Task{half},
+					Assignees: map[int]Assignee{7: {AllocationPercent: 50}},
+				}
+			}(),
+			want: map[int]planned{
+				1: {"2026-10-19", "2026-10-22", 4, 0},
+			},
+			critical: []int{1},
+			finish:   "2026-10-22",
+		},
+		{
+			name: "one assignee works on one task at a time, higher priority first",
+			input: func() Input {
+				low, urgent := task(1, 8), task(2, 16)
+				low.AssignedTo, urgent.AssignedTo = 7, 7
+				urgent.Priority = models.PriorityUrgent
+				return Input{
+					Tasks:     []models.Task{low, urgent},
+					Assignees: map[int]Assignee{7: {AllocationPercent: 100}},
+				}
+			}(),
+			want: map[int]planned{
+				2: {"2026-10-19", "2026-10-20", 2, 0},
+				1: {"2026-10-21", "2026-10-21", 1, 0},
+			},
+			critical: []int{2, 1},
+			finish:   "2026-10-21",
+		},
+		{
+			name: "an open prerequisite of another project holds until its due date",
+			input: func() Input {
+				external := task(9, 8)
+				external.ProjectID = 2
+				due := date(time.October, 21)
+				external.DueDate = &due
+				return Input{
+					Tasks:        []models.Task{task(1, 8), external},
+					Dependencies: []models.DependencyEdge{{From: 9, To: 1}},
+				}
+			}(),
+			want: map[int]planned{
+				1: {"2026-10-22", "2026-10-22", 1, 0},
+			},
+			critical: []int{1},
+			finish:   "2026-10-22",
+		},
+		{
+			name: "a parent spans its subtasks and adds no work",
+			input: func() Input {
+				first, second := task(2, 8), task(3, 16)
+				first.ParentTaskID, second.ParentTaskID = id(1), id(1)
+				return Input{
+					Tasks:        []models.Task{task(1, 80), first, second, task(4, 8)},
+					Dependencies: []models.DependencyEdge{{From: 2, To: 3}, {From: 1, To: 4}},
+				}
+			}(),
+			want: map[int]planned{
+				2: {"2026-10-19", "2026-10-19", 1, 0},
+				3: {"2026-10-20", "2026-10-21", 2, 0},
+				1: {"2026-10-19", "2026-10-21", 3, 0},
+				4: {"2026-10-22", "2026-10-22", 1, 0},
+			},
+			critical: []int{2, 3, 4},
+			finish:   "2026-10-22",
+		},
+		{
+			name: "done tasks take no time",
+			input: func() Input {
+				done := task(1, 40)
+				done.Status = models.TaskStatusDone
+				return Input{
+					Tasks:        []models.Task{done, task(2, 8)},
+					Dependencies: []models.DependencyEdge{{From: 1, To: 2}},
+				}
+			}(),
+			want: map[int]planned{
+				1: {"", "", 0, 0},
+				2: {"2026-10-19", "2026-10-19", 1, 0},
+			},
+			critical: []int{2},
+			finish:   "2026-10-19",
+		},
+	}
+
+	opts := Options{Start: monday, HoursPerDay: 8, HoursPerStoryPoint: 4, DefaultDays: 1}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.input.ProjectID = 1
+			result, err := Compute(tt.input, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(result.Tasks) != len(tt.want) {
+				t.Errorf("scheduled %d tasks, want %d", len(result.Tasks), len(tt.want))
+			}
+			for _, scheduled := range result.Tasks {
+				want, ok := tt.want[scheduled.TaskID]
+				if !ok {
+					t.Errorf("unexpected task %d", scheduled.TaskID)
+					continue
+				}
+				got := planned{dateString(scheduled.EarliestStart), dateString(scheduled.EarliestFinish), scheduled.DurationDays, scheduled.SlackDays}
+				if got != want {
+					t.Errorf("task %d: got %+v, want %+v", scheduled.TaskID, got, want)
+				}
+			}
+			if !slices.Equal(result.CriticalPath, tt.critical) {
+				t.Errorf("critical path %v, want %v", result.CriticalPath, tt.critical)
+			}
+			if got := dateString(result.FinishDate); got != tt.finish {
+				t.Errorf("finish %s, want %s", got, tt.finish)
+			}
+		})
+	}
+}
+
+func TestComputeCycle(t *testing.T) {
+	input := Input{
+		ProjectID:    1,
+		Tasks:        []models.Task{task(1, 8), task(2, 8), task(3, 8)},
+		Dependencies: []models.DependencyEdge{{From: 1, To: 2}, {From: 2, To: 3}, {From: 3, To: 1}},
+	}
+	_, err := Compute(input, Options{Start: monday, HoursPerDay: 8, DefaultDays: 1})
+	if !errors.Is(err, ErrCycle) {
+		t.Fatalf("got %v, want ErrCycle", err)
+	}
+}
+
+func dateString(d *models.Date) string {
+	if d == nil {
+		return ""
+	}
+	return d.String()
+}